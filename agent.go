@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// agentCommand runs benchmarks on this machine for a distributed run. Each
+// POST to /run carries the flags of a run as a JSON list of strings and is
+// answered with the report of the run, which gobench merge combines with
+// those of the other agents.
+func agentCommand(args []string) {
+	flags := flag.NewFlagSet("agent", flag.ExitOnError)
+	listen := flags.String("listen", ":7878", "Address to listen on for runs, as HOST:PORT")
+	token := flags.String("token", "", "Bearer token that runs must carry. Required, as an agent runs whatever it is sent. env:NAME reads it from environment variable NAME")
+	flags.Parse(args)
+	if *token != "" {
+		*token = secret(*token)
+	}
+	if *token == "" || flags.NArg() != 0 {
+		fmt.Println("Usage: gobench agent [-listen HOST:PORT] -token TOKEN")
+		flags.PrintDefaults()
+		os.Exit(1)
+	}
+
+	a := &agent{token: *token, busy: make(chan bool, 1)}
+	http.Handle("/run", a)
+	fmt.Println("Waiting for runs on", *listen)
+	log.Fatal(http.ListenAndServe(*listen, nil))
+}
+
+// agent serves the runs of a distributed run, one at a time
+type agent struct {
+	token string
+	busy  chan bool
+}
+
+func (a *agent) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "runs are POSTed", http.StatusMethodNotAllowed)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), []byte("Bearer "+a.token)) != 1 {
+		http.Error(w, "missing or wrong token", http.StatusUnauthorized)
+		return
+	}
+	var args []string
+	if err := json.NewDecoder(req.Body).Decode(&args); err != nil {
+		http.Error(w, "the body must be a JSON list of flags: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, arg := range args {
+		name := strings.SplitN(strings.TrimLeft(arg, "-"), "=", 2)[0]
+		if strings.HasPrefix(arg, "-") && (name == "report-json" || name == "save") {
+			http.Error(w, "the agent writes the report itself, so -"+name+" can't be given", http.StatusBadRequest)
+			return
+		}
+	}
+	select {
+	case a.busy <- true:
+		defer func() { <-a.busy }()
+	default:
+		http.Error(w, "already running a benchmark", http.StatusConflict)
+		return
+	}
+
+	fmt.Println("Running", strings.Join(args, " "))
+	report, err := runForAgent(req, args)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(report)
+}
+
+// runForAgent runs gobench with args in a process of its own, so that every
+// run starts from fresh flags, and returns its report. A run stops if the
+// request for it goes away.
+func runForAgent(req *http.Request, args []string) ([]byte, error) {
+	file, err := ioutil.TempFile("", "gobench-agent-*.json")
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	var output bytes.Buffer
+	cmd := exec.CommandContext(req.Context(), executable, append([]string{"run", "-report-json", file.Name()}, args...)...)
+	cmd.Stdout = io.MultiWriter(os.Stdout, &output)
+	cmd.Stderr = io.MultiWriter(os.Stderr, &output)
+	runErr := cmd.Run()
+	// A run that fails a -fail-on threshold exits non-zero, but still has
+	// a report
+	report, err := ioutil.ReadFile(file.Name())
+	if err != nil || len(report) == 0 {
+		return nil, fmt.Errorf("the run failed (%v): %s", runErr, output.String())
+	}
+	return report, nil
+}
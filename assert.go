@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// assertion is one -assert threshold, such as p99<100ms or error_rate<1%
+type assertion struct {
+	spec   string
+	metric string
+	op     string
+	value  float64
+}
+
+// latencyMetric reports whether metric is a latency, in milliseconds
+func latencyMetric(metric string) bool {
+	switch metric {
+	case "mean", "min", "max":
+		return true
+	}
+	if percentile, ok := strings.CutPrefix(metric, "p"); ok {
+		p, err := strconv.ParseFloat(percentile, 64)
+		return err == nil && p >= 0 && p <= 100
+	}
+	return false
+}
+
+// parseAssertion parses METRIC OP VALUE, where OP is <, <=, > or >=. Latencies
+// (p50, p99.9, mean, min, max) are in milliseconds unless they have a unit,
+// error_rate is a percentage and rps, errors and requests are plain numbers.
+func parseAssertion(spec string) (assertion, error) {
+	i := strings.IndexAny(spec, "<>")
+	if i < 1 {
+		return assertion{}, fmt.Errorf("-assert %q is not METRIC<VALUE or METRIC>VALUE", spec)
+	}
+	a := assertion{spec: spec, metric: strings.TrimSpace(spec[:i]), op: spec[i : i+1]}
+	value := spec[i+1:]
+	if strings.HasPrefix(value, "=") {
+		a.op += "="
+		value = value[1:]
+	}
+	value = strings.TrimSpace(value)
+
+	var err error
+	switch {
+	case latencyMetric(a.metric):
+		if n, numberErr := strconv.ParseFloat(value, 64); numberErr == nil {
+			a.value = n
+		} else {
+			var d time.Duration
+			d, err = time.ParseDuration(value)
+			a.value = float64(d) / float64(time.Millisecond)
+		}
+	case a.metric == "error_rate":
+		a.value, err = strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+	case a.metric == "rps" || a.metric == "errors" || a.metric == "requests":
+		a.value, err = strconv.ParseFloat(value, 64)
+	default:
+		return assertion{}, fmt.Errorf("-assert %q: unknown metric %q. Use a percentile such as p99, mean, min, max, rps, error_rate, errors or requests", spec, a.metric)
+	}
+	if err != nil {
+		return assertion{}, fmt.Errorf("-assert %q: can't read %q as a value of %s", spec, value, a.metric)
+	}
+	return a, nil
+}
+
+// measure returns the value of the assertion's metric in a run
+func (a assertion) measure(stats *runStats) float64 {
+	sum := total(stats.results)
+	latencies := stats.collector.latencies
+	switch a.metric {
+	case "mean":
+		return latencies.Mean() / 1000
+	case "min":
+		return latencyMilliseconds(latencies.Min())
+	case "max":
+		return latencyMilliseconds(latencies.Max())
+	case "rps":
+		return float64(sum.success) / stats.elapsed.Seconds()
+	case "errors":
+		return float64(sum.networkFailed + sum.badFailed)
+	case "requests":
+		return float64(sum.requests)
+	case "error_rate":
+		if sum.requests == 0 {
+			return 0
+		}
+		return float64(sum.networkFailed+sum.badFailed) / float64(sum.requests) * 100
+	}
+	p, _ := strconv.ParseFloat(strings.TrimPrefix(a.metric, "p"), 64)
+	return latencyMilliseconds(latencies.ValueAtPercentile(p))
+}
+
+func (a assertion) holds(measured float64) bool {
+	switch a.op {
+	case "<":
+		return measured < a.value
+	case "<=":
+		return measured <= a.value
+	case ">":
+		return measured > a.value
+	}
+	return measured >= a.value
+}
+
+// checkAssertions prints whether each assertion held for the run and returns
+// false if any didn't
+func checkAssertions(assertions []assertion, stats *runStats) bool {
+	ok := true
+	for _, a := range assertions {
+		measured := a.measure(stats)
+		unit := ""
+		switch {
+		case latencyMetric(a.metric):
+			unit = " ms"
+		case a.metric == "error_rate":
+			unit = "%"
+		}
+		if a.holds(measured) {
+			fmt.Printf("Assertion passed: %s (%s was %.2f%s)\n", a.spec, a.metric, measured, unit)
+		} else {
+			failure := fmt.Sprintf("Assertion failed: %s (%s was %.2f%s)", a.spec, a.metric, measured, unit)
+			if quiet {
+				// Say why the run failed even when told to be quiet
+				fmt.Fprintln(os.Stderr, failure)
+			} else {
+				fmt.Println(red(failure))
+			}
+			ok = false
+		}
+	}
+	return ok
+}
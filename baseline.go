@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// failRule is one -fail-on limit on how much a metric may change from the
+// baseline: p99>+10% fails the run if p99 rose by more than 10%, rps<-5% if
+// the rate fell by more than 5%
+type failRule struct {
+	spec    string
+	metric  string
+	above   bool
+	percent float64
+}
+
+// failRuleMetric reports whether name is a metric that compareReports returns
+func failRuleMetric(name string) bool {
+	switch name {
+	case "rps", "errors", "mean", "max":
+		return true
+	}
+	if percentile, ok := strings.CutPrefix(name, "p"); ok {
+		for _, p := range reportPercentiles {
+			if percentile == strconv.FormatFloat(p, 'f', -1, 64) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseFailOn parses comma separated rules such as "p99>+10%,rps<-5%"
+func parseFailOn(spec string) ([]failRule, error) {
+	var rules []failRule
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		i := strings.IndexAny(field, "<>")
+		if i < 1 || !strings.HasSuffix(field, "%") {
+			return nil, fmt.Errorf("-fail-on rule %q is not METRIC>+N%% or METRIC<-N%%", field)
+		}
+		rule := failRule{spec: field, metric: field[:i], above: field[i] == '>'}
+		if !failRuleMetric(rule.metric) {
+			return nil, fmt.Errorf("-fail-on rule %q: unknown metric %q. Use rps, errors, mean, max or a percentile such as p99", field, rule.metric)
+		}
+		var err error
+		if rule.percent, err = strconv.ParseFloat(strings.TrimSuffix(field[i+1:], "%"), 64); err != nil {
+			return nil, fmt.Errorf("-fail-on rule %q: %q is not a percentage", field, field[i+1:])
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// violated reports whether the change in d breaks the rule
+func (r failRule) violated(d metricDelta) bool {
+	if d.name != r.metric {
+		return false
+	}
+	if r.above {
+		return d.change() > r.percent
+	}
+	return d.change() < r.percent
+}
+
+// checkBaseline prints how the run in current compares with baseline and
+// returns the -fail-on rules it broke
+func checkBaseline(baseline *jsonReport, current *jsonReport, rules []failRule) []string {
+	deltas := compareReports(baseline, current)
+	var broken []string
+	printDeltas(deltas, func(d metricDelta) bool {
+		violated := false
+		for _, rule := range rules {
+			if rule.violated(d) {
+				broken = append(broken, fmt.Sprintf("%s (%s changed %+.1f%%)", rule.spec, d.name, d.change()))
+				violated = true
+			}
+		}
+		return violated
+	})
+	return broken
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// payload is one request body
+type payload struct {
+	data     []byte
+	template *template
+
+	// minSize and maxSize are set for a random body with a size in that
+	// range, taken from data
+	minSize int
+	maxSize int
+
+	// compressed is the body compressed for -compress-body, when it is the
+	// same for every request
+	compressed []byte
+}
+
+func newPayload(data []byte) (*payload, error) {
+	t, err := parseTemplate(string(data))
+	if err != nil {
+		return nil, err
+	}
+	p := &payload{data: data, template: t}
+	if compressBody && t == nil {
+		p.compressed = gzipBytes(data)
+	}
+	return p, nil
+}
+
+// body returns the payload with any placeholders in it filled in from row,
+// compressed if -compress-body is set
+func (p *payload) body(row []string) []byte {
+	if p.compressed != nil {
+		return p.compressed
+	}
+	if compressBody {
+		return gzipBytes(p.uncompressed(row))
+	}
+	return p.uncompressed(row)
+}
+
+func (p *payload) uncompressed(row []string) []byte {
+	if p.maxSize > 0 {
+		size := p.minSize + rand.Intn(p.maxSize-p.minSize+1)
+		offset := rand.Intn(len(p.data) - size + 1)
+		return p.data[offset : offset+size]
+	}
+	if p.template != nil {
+		return []byte(p.template.expand(row))
+	}
+	return p.data
+}
+
+// loadPayloads reads every file in dir, in order of name, as a request body.
+// Hidden files and subdirectories are skipped.
+func loadPayloads(dir string) ([]*payload, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var payloads []*payload
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		p, err := newPayload(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", entry.Name(), err)
+		}
+		payloads = append(payloads, p)
+	}
+	if len(payloads) == 0 {
+		return nil, fmt.Errorf("no files")
+	}
+	return payloads, nil
+}
+
+// randomPayload returns a payload of random bytes, of a size given as N or
+// MIN-MAX with an optional k, m or g suffix, chosen afresh for each request
+// when it is a range
+func randomPayload(size string) (*payload, error) {
+	low, high, isRange := strings.Cut(size, "-")
+	minSize, err := parseSize(low)
+	if err != nil {
+		return nil, err
+	}
+	maxSize := minSize
+	if isRange {
+		if maxSize, err = parseSize(high); err != nil {
+			return nil, err
+		}
+	}
+	if maxSize < 1 || minSize > maxSize {
+		return nil, fmt.Errorf("invalid size %q", size)
+	}
+	// Bodies of random size are taken from anywhere in a buffer of twice
+	// the largest size, so that they don't all start with the same bytes
+	length := maxSize
+	if isRange {
+		length *= 2
+	}
+	data := make([]byte, length)
+	rand.Read(data)
+	return &payload{data: data, minSize: minSize, maxSize: maxSize}, nil
+}
+
+// parseSize parses a number of bytes with an optional k, m or g suffix
+func parseSize(size string) (int, error) {
+	size = strings.ToLower(strings.TrimSpace(size))
+	multiplier := 1
+	switch {
+	case strings.HasSuffix(size, "k"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(size, "m"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(size, "g"):
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		size = size[:len(size)-1]
+	}
+	n, err := strconv.Atoi(size)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	return n * multiplier, nil
+}
+
+// gzipBytes returns data compressed with gzip
+func gzipBytes(data []byte) []byte {
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	writer.Write(data)
+	writer.Close()
+	return compressed.Bytes()
+}
+
+// gunzipBytes returns data decompressed with gzip
+func gunzipBytes(data []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
+
+// gzipStream returns a reader of what r reads compressed with gzip, which is
+// compressed as it is read. Closing it stops the compression, and closes r if
+// it can be, when the request ends before the whole body was sent.
+func gzipStream(r io.Reader) io.ReadCloser {
+	reader, writer := io.Pipe()
+	go func() {
+		compressor := gzip.NewWriter(writer)
+		_, err := io.Copy(compressor, r)
+		if err == nil {
+			err = compressor.Close()
+		}
+		writer.CloseWithError(err)
+	}()
+	return &gzipReader{PipeReader: reader, source: r}
+}
+
+type gzipReader struct {
+	*io.PipeReader
+	source io.Reader
+}
+
+func (g *gzipReader) Close() error {
+	g.PipeReader.CloseWithError(io.ErrClosedPipe)
+	if closer, ok := g.source.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/glentiki/hdrhistogram"
+	"github.com/olekukonko/tablewriter"
+)
+
+// breakdownResult is the statistics of one slice of the requests in a run
+type breakdownResult struct {
+	requests  int64
+	success   int64
+	failed    int64
+	latencies *hdrhistogram.Histogram
+}
+
+// breakdown splits the results of a run by some property of each request,
+// such as the value of a header
+type breakdown struct {
+	title   string
+	results map[string]*breakdownResult
+}
+
+func newBreakdown(title string) *breakdown {
+	return &breakdown{title: title, results: make(map[string]*breakdownResult)}
+}
+
+func (b *breakdown) record(key string, res *resp) {
+	result, ok := b.results[key]
+	if !ok {
+		// Fewer significant figures than the run-wide histogram keep
+		// breakdowns with many keys from using a lot of memory
+		result = &breakdownResult{latencies: newLatencyHistogram(3)}
+		b.results[key] = result
+	}
+	result.requests++
+	if res.status >= 200 && res.status < 300 && !res.tooSlow {
+		result.success++
+		recordLatency(result.latencies, res.latency)
+	} else {
+		result.failed++
+	}
+}
+
+func (b *breakdown) print(elapsed time.Duration) {
+	keys := make([]string, 0, len(b.results))
+	for key := range b.results {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	seconds := elapsed.Seconds()
+	if seconds <= 0 {
+		seconds = 1
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetRowSeparator("-")
+	table.SetHeader([]string{
+		b.title,
+		"Requests",
+		"Success",
+		"Failed",
+		"Rate",
+		"50%",
+		"99%",
+		"Max",
+	})
+	for _, key := range keys {
+		result := b.results[key]
+		table.Append([]string{
+			bold(key),
+			fmt.Sprintf("%d", result.requests),
+			fmt.Sprintf("%d", result.success),
+			fmt.Sprintf("%d", result.failed),
+			fmt.Sprintf("%.0f hits/sec", float64(result.success)/seconds),
+			formatLatency(result.latencies.ValueAtPercentile(50)),
+			formatLatency(result.latencies.ValueAtPercentile(99)),
+			formatLatency(result.latencies.Max()),
+		})
+	}
+	table.Render()
+	fmt.Println("")
+}
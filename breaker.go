@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	breakerClosed = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// breaker is a client side circuit breaker. It opens after threshold
+// consecutive failures, keeping the client idle for cooldown, then lets a
+// single probe through: the breaker closes if the probe succeeds and opens
+// again if it fails.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+	state     int
+	failures  int
+	openedAt  time.Time
+	result    *Result
+}
+
+// wait blocks while the breaker is open. It returns false if ctx is done
+// first.
+func (b *breaker) wait(ctx context.Context) bool {
+	if b.state != breakerOpen {
+		return true
+	}
+	idle := time.Now()
+	ok := sleep(ctx, time.Until(b.openedAt.Add(b.cooldown)))
+	b.result.breakerOpenTime += time.Since(idle)
+	if !ok {
+		return false
+	}
+	b.state = breakerHalfOpen
+	b.result.breakerHalfOpened++
+	return true
+}
+
+// record updates the breaker with the outcome of a request, where a status
+// code of 0 is a network failure
+func (b *breaker) record(statusCode int) {
+	if statusCode != 0 && statusCode < 500 {
+		b.failures = 0
+		if b.state == breakerHalfOpen {
+			b.state = breakerClosed
+			b.result.breakerClosed++
+		}
+		return
+	}
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+		b.result.breakerOpened++
+	}
+}
+
+func printBreaker(sum Result) {
+	fmt.Printf("Breaker opened:                 %10d\n", sum.breakerOpened)
+	fmt.Printf("Breaker half-opened:            %10d\n", sum.breakerHalfOpened)
+	fmt.Printf("Breaker closed:                 %10d\n", sum.breakerClosed)
+	fmt.Printf("Breaker open time:              %10.2f sec\n", sum.breakerOpenTime.Seconds())
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/glentiki/hdrhistogram"
+	"github.com/olekukonko/tablewriter"
+)
+
+// burst lets count requests through, across all clients, at the start of
+// each interval and holds the clients back for the rest of it
+type burst struct {
+	sync.Mutex
+	start    time.Time
+	count    int
+	interval time.Duration
+
+	// current is the burst being sent and sent how many of its requests
+	// have gone
+	current int
+	sent    int
+}
+
+// reset starts the bursts again from start
+func (b *burst) reset(start time.Time) {
+	b.Lock()
+	b.start = start
+	b.current = 0
+	b.sent = 0
+	b.Unlock()
+}
+
+// wait blocks until a request of a burst may be sent, or ctx is done, and
+// returns the index of the burst.
+func (b *burst) wait(ctx context.Context) int {
+	for {
+		b.Lock()
+		current := int(time.Since(b.start) / b.interval)
+		if current > b.current {
+			b.current = current
+			b.sent = 0
+		}
+		if b.sent < b.count {
+			b.sent++
+			b.Unlock()
+			return current
+		}
+		next := b.start.Add(time.Duration(current+1) * b.interval)
+		b.Unlock()
+		if !sleep(ctx, time.Until(next)) {
+			return current
+		}
+	}
+}
+
+type burstResult struct {
+	requests  int64
+	success   int64
+	failed    int64
+	last      time.Time
+	latencies *hdrhistogram.Histogram
+}
+
+// recordBurst adds a response to the statistics of the burst it was sent in,
+// growing results as new bursts start.
+func recordBurst(results []*burstResult, res *resp) []*burstResult {
+	for len(results) <= res.cycle {
+		results = append(results, &burstResult{latencies: newLatencyHistogram(3)})
+	}
+	result := results[res.cycle]
+	result.requests++
+	if res.status >= 200 && res.status < 300 && !res.tooSlow {
+		result.success++
+		recordLatency(result.latencies, res.latency)
+	} else {
+		result.failed++
+	}
+	if end := res.sent.Add(time.Duration(res.latency) * time.Microsecond); end.After(result.last) {
+		result.last = end
+	}
+	return results
+}
+
+// printBursts prints the statistics of each burst, including how long it
+// took from its start until the last of its responses came back
+func printBursts(results []*burstResult, b *burst) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetRowSeparator("-")
+	table.SetHeader([]string{
+		"Burst",
+		"Requests",
+		"Success",
+		"Failed",
+		"Drain",
+		"50%",
+		"99%",
+		"Max",
+	})
+	for i, result := range results {
+		if result.requests == 0 {
+			continue
+		}
+		drain := result.last.Sub(b.start.Add(time.Duration(i) * b.interval))
+		table.Append([]string{
+			bold(fmt.Sprintf("%d", i+1)),
+			fmt.Sprintf("%d", result.requests),
+			fmt.Sprintf("%d", result.success),
+			fmt.Sprintf("%d", result.failed),
+			fmt.Sprintf("%v ms", drain.Milliseconds()),
+			formatLatency(result.latencies.ValueAtPercentile(50)),
+			formatLatency(result.latencies.ValueAtPercentile(99)),
+			formatLatency(result.latencies.Max()),
+		})
+	}
+	table.Render()
+	fmt.Println("")
+}
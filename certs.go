@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/youmark/pkcs8"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// clientCertificate holds the certificate presented on new connections. It is
+// swapped when the certificate is reloaded so that connections made after a
+// reload present the new identity while existing ones carry on.
+var clientCertificate atomic.Pointer[tls.Certificate]
+
+func getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return clientCertificate.Load(), nil
+}
+
+// reloadClientCertificates reloads the client certificate from disk on SIGHUP
+// and, if interval is positive, every interval. A failed reload is reported
+// and the previous certificate kept.
+func reloadClientCertificates(interval time.Duration) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	var tick <-chan time.Time
+	if interval > 0 {
+		tick = time.NewTicker(interval).C
+	}
+	for {
+		select {
+		case <-hangup:
+		case <-tick:
+		}
+		cert, err := loadClientCertificate()
+		if err != nil {
+			log.Println("Error reloading client certificate:", err)
+			continue
+		}
+		clientCertificate.Store(&cert)
+		if leaf, err := x509.ParseCertificate(cert.Certificate[0]); err == nil {
+			log.Printf("Reloaded client certificate %s (serial %s, expires %s)", leaf.Subject, leaf.SerialNumber, leaf.NotAfter.Format(time.RFC3339))
+		}
+	}
+}
+
+// loadClientCertificate loads the certificate presented for MATLS from either
+// a PEM cert and key pair or a PKCS#12 bundle. With neither configured it
+// returns an empty certificate.
+func loadClientCertificate() (tls.Certificate, error) {
+	switch {
+	case pkcs12File != "":
+		return loadPKCS12(pkcs12File, secret(pkcs12Password))
+	case mtlsCertFile != "" && keyPassword != "":
+		return loadEncryptedKeyPair(mtlsCertFile, mtlsKeyFile, secret(keyPassword))
+	case mtlsCertFile != "":
+		return tls.LoadX509KeyPair(mtlsCertFile, mtlsKeyFile)
+	}
+	return tls.Certificate{}, nil
+}
+
+// loadPKCS12 loads the key, certificate and any intermediate certificates
+// from a .p12/.pfx bundle
+func loadPKCS12(path string, password string) (tls.Certificate, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	key, leaf, chain, err := pkcs12.DecodeChain(data, password)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("%s: %w", path, err)
+	}
+	cert := tls.Certificate{
+		Certificate: [][]byte{leaf.Raw},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}
+	for _, ca := range chain {
+		cert.Certificate = append(cert.Certificate, ca.Raw)
+	}
+	return cert, nil
+}
+
+// loadEncryptedKeyPair loads a PEM cert and a passphrase protected PEM key.
+// Both PKCS#8 (ENCRYPTED PRIVATE KEY) and legacy OpenSSL (Proc-Type:
+// 4,ENCRYPTED) encryption are understood.
+func loadEncryptedKeyPair(certFile string, keyFile string, password string) (tls.Certificate, error) {
+	certPEM, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyPEM, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return tls.Certificate{}, fmt.Errorf("%s: no PEM data found", keyFile)
+	}
+
+	var decrypted *pem.Block
+	switch {
+	case block.Type == "ENCRYPTED PRIVATE KEY":
+		key, err := pkcs8.ParsePKCS8PrivateKey(block.Bytes, []byte(password))
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("%s: %w", keyFile, err)
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("%s: %w", keyFile, err)
+		}
+		decrypted = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	case x509.IsEncryptedPEMBlock(block):
+		der, err := x509.DecryptPEMBlock(block, []byte(password))
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("%s: %w", keyFile, err)
+		}
+		decrypted = &pem.Block{Type: block.Type, Bytes: der}
+	default:
+		return tls.Certificate{}, errors.New(keyFile + ": key is not encrypted but -key-pass was given")
+	}
+	return tls.X509KeyPair(certPEM, pem.EncodeToMemory(decrypted))
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+const (
+	// asciiChartHeight is the number of lines in the -chart plot
+	asciiChartHeight = 10
+	// asciiChartWidth is the most columns the plot takes. Longer runs have
+	// several seconds averaged in each column.
+	asciiChartWidth = 72
+)
+
+// printThroughputChart plots the requests per second over a run that took
+// elapsed in the terminal, to show ramps and collapses without exporting
+// anything
+func printThroughputChart(timeline []timeSlot, elapsed time.Duration) {
+	// The end of the run cuts its last second short, so the rate of that
+	// second is taken over what there was of it
+	rates := make([]float64, 0, len(timeline))
+	for second, slot := range timeline {
+		length := math.Min(1, elapsed.Seconds()-float64(second))
+		if length < 0.1 {
+			break
+		}
+		rates = append(rates, float64(slot.requests)/length)
+	}
+	if len(rates) == 0 {
+		return
+	}
+
+	perColumn := (len(rates) + asciiChartWidth - 1) / asciiChartWidth
+	columns := make([]float64, (len(rates)+perColumn-1)/perColumn)
+	var max float64
+	for i := range columns {
+		var sum float64
+		var seconds int
+		for second := i * perColumn; second < (i+1)*perColumn && second < len(rates); second++ {
+			sum += rates[second]
+			seconds++
+		}
+		columns[i] = sum / float64(seconds)
+		max = math.Max(max, columns[i])
+	}
+	if max == 0 {
+		max = 1
+	}
+	// Short runs have each second drawn several columns wide
+	repeat := asciiChartWidth / len(columns)
+	if repeat > 6 {
+		repeat = 6
+	}
+
+	fmt.Println("Requests/sec over the run:")
+	for line := asciiChartHeight; line > 0; line-- {
+		label := ""
+		if line == asciiChartHeight || line == asciiChartHeight/2 {
+			label = fmt.Sprintf("%.0f", max*float64(line)/asciiChartHeight)
+		}
+		var row strings.Builder
+		for _, value := range columns {
+			// A column reaches a line when it is at least halfway up to it
+			mark := " "
+			if value/max*asciiChartHeight >= float64(line)-0.5 {
+				mark = "#"
+			}
+			row.WriteString(strings.Repeat(mark, repeat))
+		}
+		fmt.Printf("%8s |%s\n", label, row.String())
+	}
+	width := len(columns) * repeat
+	end := fmt.Sprintf("%ds", len(rates))
+	fmt.Printf("%8s +%s\n", "0", strings.Repeat("-", width))
+	fmt.Printf("%8s  0s%s%s\n", "", strings.Repeat(" ", int(math.Max(1, float64(width-2-len(end))))), end)
+	if perColumn > 1 {
+		fmt.Printf("%8s  (%d seconds a column)\n", "", perColumn)
+	}
+	fmt.Println("")
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+// cipherPresets are the named lists -cipher takes in place of suite names.
+// They only apply to TLS 1.2 and below, as crypto/tls doesn't let the TLS 1.3
+// suites be chosen.
+var cipherPresets = []struct {
+	name   string
+	suites []uint16
+}{
+	// The AES-GCM suites with ECDHE key exchange that FIPS 140 allows
+	{"FIPS", []uint16{
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	}},
+	// Forward secret AEAD suites only, as modern browsers offer
+	{"MODERN", []uint16{
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+	}},
+	// MODERN and the CBC and RSA key exchange suites that old clients use
+	{"LEGACY", []uint16{
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+		tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+		tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+		tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+		tls.TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA,
+		tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA,
+	}},
+}
+
+// parseCipherSuites turns a comma separated list of cipher suite names and
+// presets into the suites for tls.Config.CipherSuites, in the order given
+// and without repeats
+func parseCipherSuites(list string) ([]uint16, error) {
+	var suites []uint16
+	seen := make(map[uint16]bool)
+	add := func(id uint16) {
+		if !seen[id] {
+			seen[id] = true
+			suites = append(suites, id)
+		}
+	}
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		preset := false
+		for _, p := range cipherPresets {
+			if strings.EqualFold(name, p.name) {
+				for _, id := range p.suites {
+					add(id)
+				}
+				preset = true
+				break
+			}
+		}
+		if preset {
+			continue
+		}
+		ok, id := checkCipherSuiteName(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite: %s", name)
+		}
+		add(id)
+	}
+	return suites, nil
+}
+
+func printCipherPresets() {
+	for _, p := range cipherPresets {
+		names := make([]string, len(p.suites))
+		for i, id := range p.suites {
+			names[i] = tls.CipherSuiteName(id)
+		}
+		fmt.Printf("%s: %s\n", p.name, strings.Join(names, ","))
+	}
+}
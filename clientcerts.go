@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// clientCertCursor picks the -client-certs identity of the next client to
+// start
+var clientCertCursor uint64
+
+// loadClientCertificates loads the cert and key pairs of -client-certs. path
+// is either a directory of NAME.crt or NAME.pem certificates, each with its
+// NAME.key, or a file with a "CERT KEY" pair of paths, relative to the file,
+// on each line.
+func loadClientCertificates(path string) ([]tls.Certificate, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	var pairs [][2]string
+	if info.IsDir() {
+		entries, err := ioutil.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			ext := filepath.Ext(entry.Name())
+			if entry.IsDir() || (ext != ".crt" && ext != ".pem") {
+				continue
+			}
+			key := filepath.Join(path, strings.TrimSuffix(entry.Name(), ext)+".key")
+			if _, err := os.Stat(key); err != nil {
+				continue
+			}
+			pairs = append(pairs, [2]string{filepath.Join(path, entry.Name()), key})
+		}
+		sort.Slice(pairs, func(i, j int) bool { return pairs[i][0] < pairs[j][0] })
+	} else {
+		lines, err := readLines(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range lines {
+			if line = strings.TrimSpace(line); line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			fields := strings.Fields(line)
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("%s: %q is not a CERT KEY pair", path, line)
+			}
+			for i, field := range fields {
+				if !filepath.IsAbs(field) {
+					fields[i] = filepath.Join(filepath.Dir(path), field)
+				}
+			}
+			pairs = append(pairs, [2]string{fields[0], fields[1]})
+		}
+	}
+	if len(pairs) == 0 {
+		return nil, fmt.Errorf("no certificate and key pairs in %s", path)
+	}
+
+	certs := make([]tls.Certificate, 0, len(pairs))
+	for _, pair := range pairs {
+		var cert tls.Certificate
+		if keyPassword != "" {
+			cert, err = loadEncryptedKeyPair(pair[0], pair[1], secret(keyPassword))
+		} else {
+			cert, err = tls.LoadX509KeyPair(pair[0], pair[1])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", pair[0], err)
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// withClientCertificate returns a client with its own connections that
+// present cert, so that no other client's identity is reused on them
+func (c *Configuration) withClientCertificate(cert tls.Certificate) *http.Client {
+	client := c.withKeepAlive(c.keepAlive).myClient
+	transport := client.Transport.(*http.Transport)
+	transport.TLSClientConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		return &cert, nil
+	}
+	return client
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/glentiki/hdrhistogram"
+	"github.com/olekukonko/tablewriter"
+)
+
+const (
+	// batchSize is how many responses a client collects before handing them
+	// to the collector
+	batchSize = 256
+	// batchInterval is the longest a client holds on to a response, so that
+	// slow clients still report promptly
+	batchInterval = 100 * time.Millisecond
+)
+
+// respBatch collects a client's responses and hands them to the collector in
+// batches. Sending one batch rather than every response keeps the time a
+// client can spend blocked on a busy collector, which would otherwise delay
+// its next request, negligible. A timer hands over what there is after
+// batchInterval, so a client that is waiting on its pacer, thinking or on a
+// slow request doesn't hold on to its responses.
+type respBatch struct {
+	sync.Mutex
+	out   chan<- []resp
+	resps []resp
+	timer *time.Timer
+}
+
+func newRespBatch(out chan<- []resp) *respBatch {
+	return &respBatch{
+		out:   out,
+		resps: make([]resp, 0, batchSize),
+	}
+}
+
+func (b *respBatch) add(res resp) {
+	b.Lock()
+	defer b.Unlock()
+	b.resps = append(b.resps, res)
+	switch {
+	case len(b.resps) == cap(b.resps):
+		b.send()
+	case len(b.resps) == 1:
+		b.timer = time.AfterFunc(batchInterval, b.flush)
+	}
+}
+
+// flush hands over any responses collected so far
+func (b *respBatch) flush() {
+	b.Lock()
+	defer b.Unlock()
+	b.send()
+}
+
+func (b *respBatch) send() {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if len(b.resps) > 0 {
+		b.out <- b.resps
+		b.resps = make([]resp, 0, batchSize)
+	}
+}
+
+// collector aggregates the responses from all clients. It is only used from
+// the goroutine that receives the batches, so it needs no locking.
+type collector struct {
+	latencies       *hdrhistogram.Histogram
+	corrected       *hdrhistogram.Histogram
+	non2xxLatencies *hdrhistogram.Histogram
+	failedLatencies *hdrhistogram.Histogram
+	slowLatencies   *hdrhistogram.Histogram
+	fullLatencies   *hdrhistogram.Histogram
+	start           time.Time
+	dutyCycle       *dutyCycle
+	cycles          []*cycleResult
+	stages          []stage
+	stageResults    []*breakdownResult
+	target          *latencyTarget
+	burst           *burst
+	bursts          []*burstResult
+	interval        *intervalSummary
+	messageCount    int64
+	responses       int64
+	statuses        map[int]int64
+	networkErrors   map[string]int64
+	timeline        []timeSlot
+	errors          int64
+	maxLatency      int64
+	clamped         int64
+	trailers        map[string]int64
+	malformed       map[[2]string]int64
+	drift           *driftDetector
+	bodyHashes      *bodyHashes
+	matrix          *breakdown
+	personas        *breakdown
+	operations      *breakdown
+	urls            *breakdown
+	requestLog      *requestLog
+	statsd          *statsdClient
+	jtl             *jtlLog
+	influx          *influxExporter
+	otlp            *otlpExporter
+	latencyTimeline *latencyTimeline
+	sizes           *responseSizes
+	slowest         *slowRequests
+}
+
+func newCollector(configuration *Configuration, start time.Time) *collector {
+	c := &collector{
+		latencies:     newLatencyHistogram(histogramPrecision),
+		fullLatencies: newLatencyHistogram(histogramPrecision),
+		start:         start,
+		dutyCycle:     configuration.dutyCycle,
+		stages:        configuration.stages,
+		target:        configuration.target,
+		burst:         configuration.burst,
+		maxLatency:    -1,
+		trailers:      make(map[string]int64),
+		statuses:      make(map[int]int64),
+		networkErrors: make(map[string]int64),
+		sizes:         newResponseSizes(),
+		malformed:     make(map[[2]string]int64),
+	}
+	if summaryInterval > 0 || statsInterval > 0 {
+		c.interval = newIntervalSummary(start)
+	}
+	if configuration.stages != nil {
+		c.stageResults = newStageResults(configuration.stages)
+	}
+	if configuration.matrixHeader != "" {
+		c.matrix = newBreakdown(configuration.matrixHeader)
+	}
+	if len(configuration.personas) > 0 {
+		c.personas = newBreakdown("Persona")
+	}
+	if configuration.mix != nil {
+		c.operations = newBreakdown("Operation")
+	}
+	if len(configuration.urls) > 1 {
+		c.urls = newBreakdown("URL")
+	}
+	if hashBodies {
+		c.bodyHashes = newBodyHashes()
+	}
+	if driftWindow > 0 {
+		c.drift = newDriftDetector(driftWindow, driftThreshold, driftWebhook)
+	}
+	if logRequests != "" {
+		var err error
+		if c.requestLog, err = newRequestLog(logRequests, start); err != nil {
+			log.Fatalf("Error creating request log %s: %s", logRequests, err)
+		}
+	}
+	if influxURL != "" {
+		c.influx = newInfluxExporter(influxURL, influxToken, runLabels, start)
+	}
+	if timelineFile != "" {
+		c.latencyTimeline = newLatencyTimeline(start)
+	}
+	if otlpEndpoint != "" {
+		c.otlp = newOTLPExporter(otlpEndpoint, runLabels, start)
+	}
+	if slowest > 0 {
+		c.slowest = newSlowRequests(slowest, start)
+	}
+	if jtlFile != "" {
+		var err error
+		if c.jtl, err = newJTLLog(jtlFile); err != nil {
+			log.Fatalf("Error creating JTL file %s: %s", jtlFile, err)
+		}
+	}
+	if statsdAddress != "" {
+		var err error
+		if c.statsd, err = newStatsdClient(statsdAddress, statsdPrefix, statsdTags); err != nil {
+			log.Fatalf("Error connecting to StatsD agent %s: %s", statsdAddress, err)
+		}
+	}
+	return c
+}
+
+func (c *collector) record(batch []resp) {
+	for i := range batch {
+		res := &batch[i]
+		if res.malformed != "" {
+			c.malformed[[2]string{res.malformed, res.outcome}]++
+			continue
+		}
+		if c.requestLog != nil && (logSamplePercent >= 100 || rand.Float64()*100 < logSamplePercent) {
+			if err := c.requestLog.record(res); err != nil {
+				fmt.Println("Error writing request log:", err)
+				c.requestLog.close()
+				c.requestLog = nil
+			}
+		}
+		if c.jtl != nil {
+			if err := c.jtl.record(res); err != nil {
+				fmt.Println("Error writing JTL file:", err)
+				c.jtl.close()
+				c.jtl = nil
+			}
+		}
+		if c.statsd != nil {
+			c.statsd.record(res)
+		}
+		if c.influx != nil {
+			c.influx.record(res)
+		}
+		if c.otlp != nil {
+			c.otlp.record(res)
+		}
+		if c.latencyTimeline != nil {
+			c.latencyTimeline.record(res)
+		}
+		if c.slowest != nil {
+			c.slowest.record(res)
+		}
+		if c.dutyCycle != nil {
+			c.cycles = recordCycle(c.cycles, res)
+		}
+		if c.burst != nil {
+			c.bursts = recordBurst(c.bursts, res)
+		}
+		if c.stages != nil {
+			recordStage(c.stages, c.stageResults, c.start, res)
+		}
+		if c.bodyHashes != nil && res.status != 0 && (hashExcluded == nil || !hashExcluded.MatchString(res.endpoint)) {
+			c.bodyHashes.record(res)
+		}
+		if c.matrix != nil {
+			c.matrix.record(res.matrixValue, res)
+		}
+		if c.personas != nil {
+			c.personas.record(res.persona, res)
+		}
+		if c.operations != nil {
+			c.operations.record(res.operation, res)
+		}
+		if c.urls != nil {
+			c.urls.record(res.endpoint, res)
+		}
+		for _, trailer := range res.trailers {
+			c.trailers[trailer]++
+		}
+		c.responses++
+		if reportHTML != "" || throughputChart {
+			c.timeline = recordTimeline(c.timeline, c.start, res)
+		}
+		if res.status != 0 {
+			c.statuses[res.status]++
+			c.sizes.record(res)
+		} else if res.errorKind != "" {
+			c.networkErrors[res.errorKind]++
+		}
+		if c.interval != nil {
+			c.interval.record(res)
+		}
+		// A 2xx over -fail-over is a failure here as it is in the results
+		succeeded := res.status >= 200 && res.status < 300 && !res.tooSlow
+		if !succeeded {
+			c.errors++
+			// Kept apart so that fast errors don't flatter the latency of
+			// the successes, and to tell fast errors from slow ones
+			switch {
+			case res.status == 0:
+				if c.failedLatencies == nil {
+					c.failedLatencies = newLatencyHistogram(histogramPrecision)
+				}
+				recordLatency(c.failedLatencies, res.latency)
+			case res.tooSlow:
+				if c.slowLatencies == nil {
+					c.slowLatencies = newLatencyHistogram(histogramPrecision)
+				}
+				recordLatency(c.slowLatencies, res.latency)
+			default:
+				if c.non2xxLatencies == nil {
+					c.non2xxLatencies = newLatencyHistogram(histogramPrecision)
+				}
+				recordLatency(c.non2xxLatencies, res.latency)
+			}
+		}
+		if succeeded {
+			c.messageCount++
+			if !recordLatency(c.latencies, res.latency) {
+				c.clamped++
+			}
+			recordLatency(c.fullLatencies, res.complete)
+			if res.corrected >= 0 {
+				if c.corrected == nil {
+					c.corrected = newLatencyHistogram(histogramPrecision)
+				}
+				recordLatency(c.corrected, res.corrected)
+				if c.target != nil {
+					c.target.record(res.corrected)
+				}
+			}
+			if c.drift != nil {
+				c.drift.record(res.sent, res.latency)
+			}
+			if trackMaxLatency {
+				if c.maxLatency < 0 || res.latency > c.maxLatency {
+					c.maxLatency = res.latency
+					fmt.Println(c.messageCount, " latency:", latencyMilliseconds(res.latency), "(ms)")
+				}
+			}
+		}
+	}
+	if c.statsd != nil {
+		c.statsd.flush()
+	}
+}
+
+// errorRateMinimum is how many responses there must be before -max-error-rate
+// is checked, so that a failure among the first few doesn't end the run
+const errorRateMinimum = 100
+
+// errorLimit returns why the run should stop if it has crossed -max-errors or
+// -max-error-rate, or "" if it hasn't
+func (c *collector) errorLimit() string {
+	if maxErrors > 0 && c.errors >= maxErrors {
+		return fmt.Sprintf("%d errors reached -max-errors", c.errors)
+	}
+	if maxErrorPercent > 0 && c.responses >= errorRateMinimum {
+		if rate := float64(c.errors) / float64(c.responses) * 100; rate > maxErrorPercent {
+			return fmt.Sprintf("error rate of %.1f%% is over -max-error-rate", rate)
+		}
+	}
+	return ""
+}
+
+// printTrailers prints how many responses carried each trailer value
+func printTrailers(trailers map[string]int64) {
+	names := make([]string, 0, len(trailers))
+	for name := range trailers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetRowSeparator("-")
+	table.SetHeader([]string{"Response trailer", "Responses"})
+	for _, name := range names {
+		table.Append([]string{name, fmt.Sprintf("%d", trailers[name])})
+	}
+	table.Render()
+	fmt.Println("")
+}
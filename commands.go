@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// command is a gobench subcommand. Running gobench with flags but no command
+// is the same as gobench run.
+type command struct {
+	name    string
+	usage   string
+	summary string
+	run     func(args []string)
+}
+
+var commands = []command{
+	{"run", "[flags]", "Run a benchmark", runCommand},
+	{"console", "[flags]", "Start, stop and adjust benchmarks from a prompt", consoleCommand},
+	{"report", "[-config] FILE", "Print a report written by -report-json", reportCommand},
+	{"compare", "[-threshold N] OLD NEW", "Compare two reports written by -save or -report-json", compareCommand},
+	{"merge", "[-o FILE] REPORT...", "Merge the reports of runs that went on at the same time", mergeCommand},
+	{"agent", "[-listen ADDR] -token T", "Run the benchmarks POSTed to it for a distributed run", agentCommand},
+	{"history", "[-url URL] [DIR]", "List the reports saved in a directory, oldest first", historyCommand},
+}
+
+func init() {
+	flag.Usage = usage
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage of %s:\n", os.Args[0])
+	for _, command := range commands {
+		fmt.Fprintf(out, "  %-30s %s\n", command.name+" "+command.usage, command.summary)
+	}
+	fmt.Fprintln(out, "\nFlags of run and console:")
+	flag.PrintDefaults()
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// metricDelta is how one metric changed from one run to another
+type metricDelta struct {
+	name          string
+	unit          string
+	before        float64
+	after         float64
+	higherIsWorse bool
+}
+
+// change returns the change as a percentage of before
+func (d metricDelta) change() float64 {
+	if d.before == 0 {
+		if d.after == 0 {
+			return 0
+		}
+		return math.Inf(1)
+	}
+	return (d.after - d.before) / d.before * 100
+}
+
+// worse returns whether the metric got worse by more than threshold percent
+func (d metricDelta) worse(threshold float64) bool {
+	if d.higherIsWorse {
+		return d.change() > threshold
+	}
+	return d.change() < -threshold
+}
+
+// errorRate returns the percentage of the requests of a run that failed
+func errorRate(r *jsonReport) float64 {
+	if r.Results.Requests == 0 {
+		return 0
+	}
+	return float64(r.Results.NetworkFailed+r.Results.BadFailed) / float64(r.Results.Requests) * 100
+}
+
+// compareReports returns how the throughput, error rate and latency of the run
+// of after changed from the run of before. Metrics are named as -fail-on
+// names them: rps, errors, mean, max and p50, p99 and so on.
+func compareReports(before *jsonReport, after *jsonReport) []metricDelta {
+	deltas := []metricDelta{
+		{name: "rps", unit: "hits/sec", before: before.Results.Rate, after: after.Results.Rate},
+		{name: "errors", unit: "%", before: errorRate(before), after: errorRate(after), higherIsWorse: true},
+		{name: "mean", unit: before.Latency.Unit, before: before.Latency.Mean, after: after.Latency.Mean, higherIsWorse: true},
+	}
+	percentiles := make([]string, 0, len(before.Latency.Percentiles))
+	for percentile := range before.Latency.Percentiles {
+		if _, ok := after.Latency.Percentiles[percentile]; ok {
+			percentiles = append(percentiles, percentile)
+		}
+	}
+	sort.Slice(percentiles, func(i, j int) bool {
+		a, _ := strconv.ParseFloat(percentiles[i], 64)
+		b, _ := strconv.ParseFloat(percentiles[j], 64)
+		return a < b
+	})
+	for _, percentile := range percentiles {
+		deltas = append(deltas, metricDelta{
+			name:          "p" + percentile,
+			unit:          before.Latency.Unit,
+			before:        before.Latency.Percentiles[percentile],
+			after:         after.Latency.Percentiles[percentile],
+			higherIsWorse: true,
+		})
+	}
+	return append(deltas, metricDelta{
+		name:          "max",
+		unit:          before.Latency.Unit,
+		before:        before.Latency.Max,
+		after:         after.Latency.Max,
+		higherIsWorse: true,
+	})
+}
+
+// printDeltas prints the changes between two runs, highlighting those that
+// regressed says are regressions
+func printDeltas(deltas []metricDelta, regressed func(metricDelta) bool) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetRowSeparator("-")
+	table.SetHeader([]string{"Metric", "Before", "After", "Change", ""})
+	for _, d := range deltas {
+		change := fmt.Sprintf("%+.1f%%", d.change())
+		verdict := ""
+		if regressed(d) {
+			change = red(change)
+			verdict = red("regression")
+		}
+		table.Append([]string{
+			bold(d.name),
+			strconv.FormatFloat(d.before, 'f', 2, 64) + " " + d.unit,
+			strconv.FormatFloat(d.after, 'f', 2, 64) + " " + d.unit,
+			change,
+			verdict,
+		})
+	}
+	table.Render()
+}
+
+// compareCommand prints how a run did against an earlier one, from the reports
+// written by -save or -report-json
+func compareCommand(args []string) {
+	flags := flag.NewFlagSet("compare", flag.ExitOnError)
+	threshold := flags.Float64("threshold", 5, "Highlight metrics that got worse by more than this percentage")
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		fmt.Println("Usage: gobench compare [-threshold N] OLD NEW")
+		flags.PrintDefaults()
+		os.Exit(1)
+	}
+	colorOutput = isTerminal(os.Stdout) && os.Getenv("NO_COLOR") == ""
+
+	reports := make([]*jsonReport, 2)
+	for i, fileName := range flags.Args() {
+		var err error
+		if reports[i], err = readReport(fileName); err != nil {
+			log.Fatalf("Error reading report %s: %s", fileName, err)
+		}
+	}
+	before, after := reports[0], reports[1]
+	fmt.Printf("Before: %s, started %s\n", flags.Arg(0), before.Started.Format("2006-01-02 15:04:05"))
+	fmt.Printf("After:  %s, started %s\n", flags.Arg(1), after.Started.Format("2006-01-02 15:04:05"))
+	fmt.Println()
+	printDeltas(compareReports(before, after), func(d metricDelta) bool { return d.worse(*threshold) })
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// stringList is a flag that can be given more than once
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// sensitiveFlags are flags whose values are never printed
+var sensitiveFlags = map[string]bool{
+	"auth":           true,
+	"basic":          true,
+	"cookie":         true,
+	"key-pass":       true,
+	"p12-pass":       true,
+	"influxdb-token": true,
+	// Webhook URLs carry the credential to post to them
+	"drift-webhook": true,
+}
+
+// sensitiveHeaders are request headers whose values are never printed
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+}
+
+// sensitiveHeaderWords mark the names of other headers, such as the API keys
+// of a -headers-file, whose values are never printed
+var sensitiveHeaderWords = []string{"token", "secret", "password", "api-key", "apikey", "session", "signature"}
+
+// sensitiveHeader reports whether the value of the header name is a secret
+func sensitiveHeader(name string) bool {
+	if sensitiveHeaders[http.CanonicalHeaderKey(name)] {
+		return true
+	}
+	name = strings.ToLower(name)
+	for _, word := range sensitiveHeaderWords {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// redact hides a secret value, but not a reference to where it came from
+func redact(value string) string {
+	if value == "" || strings.HasPrefix(value, "env:") {
+		return value
+	}
+	return "<redacted>"
+}
+
+// sensitiveQueryParameters are query parameters of URLs, such as the p of an
+// InfluxDB 1 write URL, whose values are never printed
+var sensitiveQueryParameters = map[string]bool{
+	"p":        true,
+	"password": true,
+	"token":    true,
+	"secret":   true,
+	"api_key":  true,
+	"apikey":   true,
+}
+
+// redactURL hides the password and any sensitive query parameters of value if
+// it is a URL
+func redactURL(value string) string {
+	u, err := url.Parse(value)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return value
+	}
+	query := u.Query()
+	redacted := false
+	for name := range query {
+		if sensitiveQueryParameters[strings.ToLower(name)] {
+			query.Set(name, "xxxxx")
+			redacted = true
+		}
+	}
+	if redacted {
+		u.RawQuery = query.Encode()
+	}
+	if _, ok := u.User.Password(); !ok && !redacted {
+		return value
+	}
+	return u.Redacted()
+}
+
+// flagValue returns the resolved value of f with any secret redacted
+func flagValue(f *flag.Flag) string {
+	if sensitiveFlags[f.Name] {
+		return redact(f.Value.String())
+	}
+	return redactURL(f.Value.String())
+}
+
+// flagSource returns where the value of f came from, given the flags that were
+// set on the command line
+func flagSource(f *flag.Flag, given map[string]bool) string {
+	if fromEnvironment[f.Name] {
+		return "env " + envName(f.Name)
+	} else if given[f.Name] {
+		return "flag"
+	}
+	return "default"
+}
+
+// givenFlags returns the names of the flags set on the command line
+func givenFlags() map[string]bool {
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	return given
+}
+
+// printConfig prints every flag with its resolved value and where that value
+// came from, followed by the URLs that will be requested.
+func printConfig(configuration *Configuration) {
+	given := givenFlags()
+
+	fmt.Println("Configuration:")
+	flag.VisitAll(func(f *flag.Flag) {
+		fmt.Printf("  -%-20s %-40q (%s)\n", f.Name, flagValue(f), flagSource(f, given))
+	})
+
+	fmt.Printf("Method: %s\n", configuration.method)
+	fmt.Printf("URLs: %d\n", len(configuration.urls))
+	for _, url := range configuration.urls {
+		fmt.Println("  ", redactURL(url))
+	}
+	fmt.Println()
+}
+
+// printRequests prints the first count requests that a client would send,
+// built as the client builds them
+func printRequests(configuration *Configuration, count int) {
+	w := &worker{configuration: configuration}
+	if len(configuration.tokens) > 0 {
+		w.token = configuration.tokens[0]
+	}
+	var operations []*Configuration
+	if configuration.mix != nil {
+		operations = configuration.mix.configurations(configuration)
+	}
+	for i := 0; i < count; i++ {
+		var url string
+		switch {
+		case configuration.replay != nil:
+			// Clients take the requests of the log in order
+			if i >= len(configuration.replay.entries) {
+				return
+			}
+			entry := configuration.replay.entries[i]
+			w.method, url = entry.method, entry.url
+		case operations != nil:
+			url = w.pickOperation(configuration.mix, operations)
+		default:
+			if i >= len(configuration.urls) {
+				return
+			}
+			url = configuration.urls[i]
+		}
+		url, body, headers := w.prepare(url)
+		req, err := w.newRequest(url, body, headers)
+		if err != nil {
+			fmt.Println("Error: ", err.Error())
+			continue
+		}
+		fmt.Printf("Request %d:\n", i+1)
+		fmt.Printf("  %s %s\n", req.Method, req.URL)
+		if req.Host != "" {
+			fmt.Printf("  Host: %s\n", req.Host)
+		}
+		names := make([]string, 0, len(req.Header))
+		for name := range req.Header {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			for _, value := range req.Header[name] {
+				if sensitiveHeader(name) {
+					value = redact(value)
+				}
+				fmt.Printf("  %s: %s\n", name, value)
+			}
+		}
+		switch {
+		case len(body) > 0 && utf8.Valid(body):
+			preview := strings.TrimRight(string(body), "\n")
+			if len(preview) > 256 {
+				preview = preview[:256] + "..."
+			}
+			fmt.Printf("  Body (%d bytes): %s\n", len(body), preview)
+		case len(body) > 0:
+			fmt.Printf("  Body: %d bytes\n", len(body))
+		case req.ContentLength > 0:
+			fmt.Printf("  Body: %d bytes, streamed\n", req.ContentLength)
+		}
+		fmt.Println()
+	}
+}
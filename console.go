@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const consoleHelp = `Commands:
+  start          start the workload, running until stopped
+  stop           stop the workload and print its results
+  stats          print the results so far, or of the last workload
+  rate N         send N requests per second across all clients (0 for no limit)
+  clients N      use N clients, changing them without a restart if running
+  help           print this help
+  quit           stop the workload if it is running and exit`
+
+// console runs workloads on configuration under the control of commands read
+// from stdin. Every workload shares the same transport, so connections kept
+// alive by one are reused by the next.
+func console(configuration *Configuration, signalChan chan os.Signal) {
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	var running bool
+	var last *runStats
+	done := make(chan *runStats)
+	snapshots := make(chan func(*collector, time.Duration))
+
+	start := func() {
+		// Drop any interrupt that arrived while nothing was running
+		for len(signalChan) > 0 {
+			<-signalChan
+		}
+		running = true
+		go func() {
+			done <- run(configuration, signalChan, snapshots)
+		}()
+	}
+	finish := func(stats *runStats) {
+		running = false
+		last = stats
+		printResults(stats.results, stats.elapsed)
+		printLatency(stats.collector)
+	}
+	stop := func() {
+		signalChan <- os.Interrupt
+		finish(<-done)
+	}
+
+	fmt.Println("Type help for a list of commands")
+	for {
+		fmt.Print("gobench> ")
+		var line string
+		var ok bool
+		select {
+		case line, ok = <-lines:
+		case stats := <-done:
+			// Interrupted from the terminal
+			fmt.Println()
+			finish(stats)
+			continue
+		}
+		if !ok {
+			if running {
+				stop()
+			}
+			fmt.Println()
+			return
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "start":
+			if running {
+				fmt.Println("Already running")
+				continue
+			}
+			start()
+		case "stop":
+			if !running {
+				fmt.Println("Not running")
+				continue
+			}
+			stop()
+		case "stats":
+			if running {
+				printed := make(chan bool)
+				snapshots <- func(c *collector, elapsed time.Duration) {
+					fmt.Printf("Running for %.1f sec, %d successful requests (%.0f hits/sec)\n",
+						elapsed.Seconds(), c.messageCount, float64(c.messageCount)/elapsed.Seconds())
+					printLatency(c)
+					printed <- true
+				}
+				<-printed
+			} else if last != nil {
+				printResults(last.results, last.elapsed)
+				printLatency(last.collector)
+			} else {
+				fmt.Println("Nothing has run yet")
+			}
+		case "rate", "clients":
+			if len(fields) != 2 {
+				fmt.Printf("Usage: %s N\n", fields[0])
+				continue
+			}
+			n, err := strconv.ParseFloat(fields[1], 64)
+			if err != nil || n < 0 || (fields[0] == "clients" && (n < 1 || n != float64(int(n)))) {
+				fmt.Printf("Usage: %s N\n", fields[0])
+				continue
+			}
+			if fields[0] == "clients" && running {
+				if count, ok := changeClients(int(n), false); ok {
+					fmt.Println("Running with", count, "clients")
+					continue
+				}
+			}
+			// The clients read the rate without locking, so change it while
+			// nothing is running
+			restart := running
+			if restart {
+				stop()
+			}
+			if fields[0] == "clients" {
+				clients = int(n)
+			} else if n == 0 {
+				configuration.pacer = nil
+			} else {
+				configuration.pacer = newPacer(func(time.Duration) float64 { return n })
+			}
+			if restart {
+				start()
+			}
+		case "help":
+			fmt.Println(consoleHelp)
+		case "quit", "exit":
+			if running {
+				stop()
+			}
+			return
+		default:
+			fmt.Printf("Unknown command %q, type help for a list of commands\n", fields[0])
+		}
+	}
+}
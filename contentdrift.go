@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// bodyHashes tracks the hash of the response body of each URL and notices
+// when it changes mid-run, e.g. because of a deployment, an error page being
+// served or a poisoned cache.
+type bodyHashes struct {
+	last     map[string]uint64
+	distinct map[string]map[uint64]bool
+	changes  map[string]int64
+}
+
+// hashExcluded matches the -u or -f URLs whose bodies are expected to change,
+// set by -hash-exclude
+var hashExcluded *regexp.Regexp
+
+func newBodyHashes() *bodyHashes {
+	return &bodyHashes{
+		last:     make(map[string]uint64),
+		distinct: make(map[string]map[uint64]bool),
+		changes:  make(map[string]int64),
+	}
+}
+
+// record keeps the hash of the body of res under the URL it was configured
+// with, so that URLs that are different for every request, with -cache-bust
+// or placeholders, are still compared from one request to the next
+func (b *bodyHashes) record(res *resp) {
+	url := res.endpoint
+	last, seen := b.last[url]
+	b.last[url] = res.bodyHash
+	if b.distinct[url] == nil {
+		b.distinct[url] = make(map[uint64]bool)
+	}
+	b.distinct[url][res.bodyHash] = true
+	if !seen || last == res.bodyHash {
+		return
+	}
+	if b.changes[url] == 0 {
+		log.Printf("Response content changed for %s (status %d), further changes are only counted", url, res.status)
+	}
+	b.changes[url]++
+}
+
+func (b *bodyHashes) print() {
+	urls := make([]string, 0, len(b.last))
+	for url := range b.last {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetRowSeparator("-")
+	table.SetHeader([]string{"URL", "Distinct bodies", "Changes"})
+	for _, url := range urls {
+		table.Append([]string{url, fmt.Sprintf("%d", len(b.distinct[url])), fmt.Sprintf("%d", b.changes[url])})
+	}
+	table.Render()
+	fmt.Println("")
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clientChange asks the run in progress to change how many clients it has.
+// The run replies with the number of clients it then has.
+type clientChange struct {
+	n        int
+	relative bool
+	reply    chan int
+}
+
+var clientChanges = make(chan clientChange)
+
+// changeClients sets the number of clients of the run in progress to n, or
+// changes it by n if relative, and returns the new number. It returns false
+// if no run took the change.
+func changeClients(n int, relative bool) (int, bool) {
+	change := clientChange{n: n, relative: relative, reply: make(chan int, 1)}
+	select {
+	case clientChanges <- change:
+		return <-change.reply, true
+	case <-time.After(time.Second):
+		return 0, false
+	}
+}
+
+// listenControl accepts connections on address, unix:PATH or HOST:PORT, that
+// change the clients of a run while it is going with one command a line:
+//
+//	clients      print the number of clients
+//	clients N    use N clients
+//	+[N] / -[N]  add or remove N clients, 1 if N is left out
+func listenControl(address string) error {
+	network := "tcp"
+	if path, ok := strings.CutPrefix(address, "unix:"); ok {
+		network, address = "unix", path
+		// A socket left behind by an earlier run would stop this one
+		// listening
+		os.Remove(path)
+	}
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveControl(conn)
+		}
+	}()
+	return nil
+}
+
+func serveControl(conn net.Conn) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		n, relative, err := parseClientChange(fields)
+		if err != nil {
+			fmt.Fprintln(conn, "error:", err)
+			continue
+		}
+		if count, ok := changeClients(n, relative); ok {
+			fmt.Fprintln(conn, "clients", count)
+		} else {
+			fmt.Fprintln(conn, "error: no run in progress")
+		}
+	}
+}
+
+func parseClientChange(fields []string) (int, bool, error) {
+	switch {
+	case fields[0] == "clients" && len(fields) == 1:
+		return 0, true, nil
+	case fields[0] == "clients" && len(fields) == 2:
+		n, err := strconv.Atoi(fields[1])
+		if err != nil || n < 1 {
+			return 0, false, fmt.Errorf("clients needs a number of at least 1")
+		}
+		return n, false, nil
+	case len(fields) == 1 && (fields[0][0] == '+' || fields[0][0] == '-'):
+		if len(fields[0]) == 1 {
+			fields[0] += "1"
+		}
+		n, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return 0, false, fmt.Errorf("%q is not +N or -N", fields[0])
+		}
+		return n, true, nil
+	}
+	return 0, false, fmt.Errorf("unknown command %q", strings.Join(fields, " "))
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+)
+
+// newCookieClient returns a copy of base with its own cookie jar, sharing its
+// transport so that connections are still pooled. The jar starts with the
+// -cookie cookies for the whole of the host of every URL, as without a path
+// and domain the jar would only send them to the URL's directory.
+func newCookieClient(configuration *Configuration, base *http.Client) *http.Client {
+	jar, _ := cookiejar.New(nil)
+	for _, u := range configuration.urls {
+		target, err := url.Parse(u)
+		if err != nil {
+			continue
+		}
+		cookies := make([]*http.Cookie, len(configuration.cookies))
+		for i, cookie := range configuration.cookies {
+			scoped := *cookie
+			scoped.Path = "/"
+			scoped.Domain = target.Hostname()
+			cookies[i] = &scoped
+		}
+		jar.SetCookies(target, cookies)
+	}
+	client := *base
+	client.Jar = jar
+	return &client
+}
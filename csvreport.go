@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// csvColumns are the columns of a CSV summary. Columns are only ever added at
+// the end, so that files appended to run after run stay readable.
+var csvColumns = []string{
+	"timestamp",
+	"duration_seconds",
+	"clients",
+	"method",
+	"urls",
+	"requests",
+	"success",
+	"errors",
+	"network_failed",
+	"bad_failed",
+	"rps",
+	"p50_ms",
+	"p90_ms",
+	"p99_ms",
+	"p99.9_ms",
+	"max_ms",
+	"mean_ms",
+	"read_bytes_per_second",
+	"write_bytes_per_second",
+}
+
+// csvRow returns the summary of a run in the order of csvColumns
+func csvRow(r *jsonReport) []string {
+	float := func(f float64) string {
+		return strconv.FormatFloat(f, 'f', 2, 64)
+	}
+	integer := func(i int64) string {
+		return strconv.FormatInt(i, 10)
+	}
+	return []string{
+		r.Started.Format(time.RFC3339),
+		float(r.Duration),
+		strconv.Itoa(clients),
+		r.Config.Method,
+		strings.Join(r.Config.URLs, " "),
+		integer(r.Results.Requests),
+		integer(r.Results.Success),
+		integer(r.Results.NetworkFailed + r.Results.BadFailed),
+		integer(r.Results.NetworkFailed),
+		integer(r.Results.BadFailed),
+		float(r.Results.Rate),
+		float(r.Latency.Percentiles["50"]),
+		float(r.Latency.Percentiles["90"]),
+		float(r.Latency.Percentiles["99"]),
+		float(r.Latency.Percentiles["99.9"]),
+		float(r.Latency.Max),
+		float(r.Latency.Mean),
+		float(r.Results.ReadThroughput),
+		float(r.Results.WriteThroughput),
+	}
+}
+
+// writeCSV writes the summary of a run as CSV to w, after a header row if
+// header is set
+func writeCSV(w io.Writer, r *jsonReport, header bool) error {
+	writer := csv.NewWriter(w)
+	if header {
+		writer.Write(csvColumns)
+	}
+	writer.Write(csvRow(r))
+	writer.Flush()
+	return writer.Error()
+}
+
+// appendCSV adds the summary of a run to the end of fileName, starting the
+// file with a header row if it is new or empty
+func appendCSV(fileName string, r *jsonReport) error {
+	file, err := os.OpenFile(fileName, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if err := writeCSV(file, r, info.Size() == 0); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
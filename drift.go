@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/glentiki/hdrhistogram"
+)
+
+// driftDetector fits a straight line through the p99 latency of successive
+// windows of the run and raises an alert when the line has risen by more than
+// threshold percent, catching gradual degradation while the test is running.
+type driftDetector struct {
+	window    time.Duration
+	threshold float64
+	webhook   string
+	start     time.Time
+	index     int
+	current   *hdrhistogram.Histogram
+	p99s      []float64
+	alerting  bool
+}
+
+func newDriftDetector(window time.Duration, threshold float64, webhook string) *driftDetector {
+	return &driftDetector{
+		window:    window,
+		threshold: threshold,
+		webhook:   webhook,
+		start:     time.Now(),
+		current:   newLatencyHistogram(3),
+	}
+}
+
+// record adds the latency of a successful request sent at sent
+func (d *driftDetector) record(sent time.Time, latency int64) {
+	if index := int(sent.Sub(d.start) / d.window); index > d.index {
+		d.closeWindow()
+		d.index = index
+	}
+	recordLatency(d.current, latency)
+}
+
+func (d *driftDetector) closeWindow() {
+	if d.current.TotalCount() == 0 {
+		return
+	}
+	d.p99s = append(d.p99s, latencyMilliseconds(d.current.ValueAtPercentile(99)))
+	d.current.Reset()
+
+	growth, first, last, slope := d.trend()
+	if len(d.p99s) < 3 {
+		return
+	}
+	if growth < d.threshold {
+		d.alerting = false
+		return
+	}
+	if d.alerting {
+		return
+	}
+	d.alerting = true
+	log.Printf("Latency drift: p99 trending up %.0f%% over %d windows of %v (%.1f ms -> %.1f ms, %+.2f ms per window)",
+		growth, len(d.p99s), d.window, first, last, slope)
+	if d.webhook != "" {
+		go d.notify(growth, first, last, slope)
+	}
+}
+
+// trend fits a least squares line through the p99 of each window and returns
+// how much it has risen as a percentage, its values at the first and last
+// window and its slope per window.
+func (d *driftDetector) trend() (growth float64, first float64, last float64, slope float64) {
+	n := float64(len(d.p99s))
+	if n < 2 {
+		return 0, 0, 0, 0
+	}
+	var sumX, sumY, sumXY, sumXX float64
+	for i, y := range d.p99s {
+		x := float64(i)
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXX += x * x
+	}
+	slope = (n*sumXY - sumX*sumY) / (n*sumXX - sumX*sumX)
+	first = (sumY - slope*sumX) / n
+	last = first + slope*(n-1)
+	if first <= 0 {
+		// Sub-millisecond latencies have no meaningful percentage growth
+		first = 1
+	}
+	return (last - first) / first * 100, first, last, slope
+}
+
+// notify posts a drift alert to the webhook
+func (d *driftDetector) notify(growth float64, first float64, last float64, slope float64) {
+	body, _ := json.Marshal(map[string]interface{}{
+		"alert":               "latency drift",
+		"percentile":          99,
+		"growth_percent":      growth,
+		"windows":             len(d.p99s),
+		"window_seconds":      d.window.Seconds(),
+		"first_ms":            first,
+		"last_ms":             last,
+		"slope_ms_per_window": slope,
+		"time":                time.Now().Format(time.RFC3339),
+	})
+	res, err := http.Post(d.webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Println("Error posting latency drift alert:", err)
+		return
+	}
+	res.Body.Close()
+}
+
+func (d *driftDetector) print() {
+	d.closeWindow()
+	growth, first, last, slope := d.trend()
+	fmt.Printf("Latency drift (p99 trend):      %+9.0f%% over %d windows of %v (%.1f ms -> %.1f ms, %+.2f ms per window)\n",
+		growth, len(d.p99s), d.window, first, last, slope)
+}
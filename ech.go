@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	dnsTypeHTTPS   = 65
+	svcParamKeyECH = 5
+)
+
+// loadECHConfig resolves the -ech flag into a serialized ECHConfigList. The
+// value is either base64, @file for a file holding the raw list or dns to
+// look the list up in the target's HTTPS DNS record.
+func loadECHConfig(value string, target string) ([]byte, error) {
+	switch {
+	case value == "dns":
+		return lookupECHConfig(target)
+	case strings.HasPrefix(value, "@"):
+		return ioutil.ReadFile(value[1:])
+	}
+	return base64.StdEncoding.DecodeString(value)
+}
+
+// lookupECHConfig queries the first nameserver in /etc/resolv.conf for the
+// HTTPS record of the target URL's host and returns its ech parameter.
+func lookupECHConfig(target string) ([]byte, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	name := u.Hostname()
+	if port := u.Port(); port != "" && port != "443" {
+		name = "_" + port + "._https." + name
+	}
+
+	conn, err := net.DialTimeout("udp", nameserver(), 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	id := uint16(rand.Intn(1 << 16))
+	if _, err := conn.Write(dnsQuery(id, name, dnsTypeHTTPS)); err != nil {
+		return nil, err
+	}
+	reply := make([]byte, 65535)
+	n, err := conn.Read(reply)
+	if err != nil {
+		return nil, err
+	}
+	config, err := parseECHFromHTTPS(reply[:n], id)
+	if err != nil {
+		return nil, fmt.Errorf("HTTPS record for %s: %w", name, err)
+	}
+	return config, nil
+}
+
+// nameserver returns the address of the first nameserver in /etc/resolv.conf
+func nameserver() string {
+	if file, err := os.Open("/etc/resolv.conf"); err == nil {
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) >= 2 && fields[0] == "nameserver" {
+				return net.JoinHostPort(fields[1], "53")
+			}
+		}
+	}
+	return "127.0.0.1:53"
+}
+
+// dnsQuery builds a recursive DNS query for name and qtype
+func dnsQuery(id uint16, name string, qtype uint16) []byte {
+	query := make([]byte, 12, 512)
+	binary.BigEndian.PutUint16(query[0:], id)
+	binary.BigEndian.PutUint16(query[2:], 0x0100) // recursion desired
+	binary.BigEndian.PutUint16(query[4:], 1)      // one question
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		query = append(query, byte(len(label)))
+		query = append(query, label...)
+	}
+	query = append(query, 0)
+	query = binary.BigEndian.AppendUint16(query, qtype)
+	query = binary.BigEndian.AppendUint16(query, 1) // class IN
+	return query
+}
+
+// skipName returns the offset just past the (possibly compressed) domain name
+// starting at offset
+func skipName(msg []byte, offset int) (int, error) {
+	for offset < len(msg) {
+		length := int(msg[offset])
+		switch {
+		case length == 0:
+			return offset + 1, nil
+		case length&0xc0 == 0xc0:
+			return offset + 2, nil
+		}
+		offset += 1 + length
+	}
+	return 0, errors.New("malformed DNS name")
+}
+
+// parseECHFromHTTPS finds the ech parameter of the first HTTPS record in a DNS
+// reply
+func parseECHFromHTTPS(msg []byte, id uint16) ([]byte, error) {
+	if len(msg) < 12 || binary.BigEndian.Uint16(msg) != id {
+		return nil, errors.New("malformed DNS reply")
+	}
+	if rcode := msg[3] & 0x0f; rcode != 0 {
+		return nil, fmt.Errorf("DNS error code %d", rcode)
+	}
+	questions := int(binary.BigEndian.Uint16(msg[4:]))
+	answers := int(binary.BigEndian.Uint16(msg[6:]))
+
+	offset := 12
+	var err error
+	for i := 0; i < questions; i++ {
+		if offset, err = skipName(msg, offset); err != nil {
+			return nil, err
+		}
+		offset += 4
+	}
+	for i := 0; i < answers; i++ {
+		if offset, err = skipName(msg, offset); err != nil {
+			return nil, err
+		}
+		if offset+10 > len(msg) {
+			return nil, errors.New("malformed DNS reply")
+		}
+		rtype := binary.BigEndian.Uint16(msg[offset:])
+		length := int(binary.BigEndian.Uint16(msg[offset+8:]))
+		offset += 10
+		if offset+length > len(msg) {
+			return nil, errors.New("malformed DNS reply")
+		}
+		rdata := msg[offset : offset+length]
+		offset += length
+		if rtype != dnsTypeHTTPS || len(rdata) < 2 || binary.BigEndian.Uint16(rdata) == 0 {
+			// Not an HTTPS record, or one in alias mode that has no parameters
+			continue
+		}
+		// The target name in an HTTPS record is never compressed
+		params, err := skipName(rdata, 2)
+		if err != nil {
+			return nil, err
+		}
+		for params+4 <= len(rdata) {
+			key := binary.BigEndian.Uint16(rdata[params:])
+			size := int(binary.BigEndian.Uint16(rdata[params+2:]))
+			params += 4
+			if params+size > len(rdata) {
+				break
+			}
+			if key == svcParamKeyECH {
+				return rdata[params : params+size], nil
+			}
+			params += size
+		}
+	}
+	return nil, errors.New("no ech parameter found")
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const envPrefix = "GOBENCH_"
+
+// fromEnvironment records the flags that were set from the environment
+var fromEnvironment = make(map[string]bool)
+
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// envName returns the environment variable that configures the named flag,
+// e.g. GOBENCH_AUTH for -auth and GOBENCH_DUTY_ON for -duty-on.
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// applyEnvironment sets every flag that wasn't given on the command line from
+// its GOBENCH_ environment variable, if there is one.
+func applyEnvironment() {
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	flag.VisitAll(func(f *flag.Flag) {
+		if given[f.Name] {
+			return
+		}
+		if value, ok := os.LookupEnv(envName(f.Name)); ok {
+			if err := flag.Set(f.Name, value); err != nil {
+				log.Fatalf("Error in %s: %s", envName(f.Name), err)
+			}
+			fromEnvironment[f.Name] = true
+		}
+	})
+}
+
+// secret resolves a sensitive flag value. A value of the form env:NAME is
+// replaced by the contents of the environment variable NAME so that the
+// secret itself never has to appear on the command line.
+func secret(value string) string {
+	if !strings.HasPrefix(value, "env:") {
+		return value
+	}
+	name := strings.TrimPrefix(value, "env:")
+	resolved, ok := os.LookupEnv(name)
+	if !ok {
+		fmt.Println("Environment variable", name, "is not set")
+		os.Exit(1)
+	}
+	return resolved
+}
+
+// expandEnv replaces ${NAME} references in lines read from files with the
+// value of the environment variable NAME. A bare $ is left alone since it is
+// legal in URLs.
+func expandEnv(line string) string {
+	return envReference.ReplaceAllStringFunc(line, func(reference string) string {
+		return os.Getenv(envReference.FindStringSubmatch(reference)[1])
+	})
+}
@@ -3,24 +3,32 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"crypto/tls"
+	"encoding/base64"
 	"flag"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"io/ioutil"
 	"log"
+	"math/rand"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
+	"regexp"
 	"runtime"
+	"sort"
+	"strconv"
+	"strings"
 	"sync/atomic"
 	"time"
+	"unicode"
 
 	"github.com/glentiki/hdrhistogram"
 	"github.com/olekukonko/tablewriter"
-	"github.com/ttacon/chalk"
 )
 
 var (
@@ -29,29 +37,178 @@ var (
 	clients            int
 	targetURL          string
 	urlsFilePath       string
+	mixSpec            string
 	keepAlive          bool
 	postDataFilePath   string
+	requestMethod      string
+	requestBody        string
+	postDataDir        string
+	bodySize           string
+	compressBody       bool
+	contentType        string
+	headersFile        string
+	cacheBust          string
+	cookieJar          bool
+	cookies            stringList
+	dataCSV            string
 	writeTimeout       int
 	readTimeout        int
 	authHeader         string
+	basicAuth          string
+	tokensFile         string
+	userAgent          string
+	userAgentsFile     string
+	accept             string
+	acceptGzip         bool
 	insecureSkipVerify bool
 	mtlsCertFile       string
 	mtlsKeyFile        string
+	keyPassword        string
+	certReloadInterval time.Duration
+	pkcs12File         string
+	pkcs12Password     string
 	trackMaxLatency    bool
 	hostHeader         string
 	resolve            string
 	dumpResponse       bool
+	requestTrailers    stringList
+	personaFlags       stringList
+	formFields         stringList
+	urlencodedFields   stringList
+	maxRetries         int
+	malformedPercent   float64
+	idempotencyKeys    bool
+	replayHeader       string
+	printConfiguration bool
+	dryRun             bool
 	cipherSuite        string
+	tlsResume          bool
+	echConfig          string
+	curveList          string
+	fallbackDelay      time.Duration
+	ipFamily           string
+	tcpInfoInterval    time.Duration
+	compareKeepAlive   bool
+	driftWindow        time.Duration
+	driftThreshold     float64
+	driftWebhook       string
+	hashBodies         bool
+	headerMatrix       string
+	retryAfter         bool
+	retryAfterMax      time.Duration
+	breakerThreshold   int
+	breakerCooldown    time.Duration
+	reportJSON         string
+	reportHTML         string
+	outputFormat       string
+	appendCSVFile      string
+	spectrumFile       string
+	timelineFile       string
+	percentiles        string
+	printSizes         bool
+	throughputChart    bool
+	slowest            int
+	quiet              bool
+	showProgress       bool
+	baselineFile       string
+	failOn             string
+	baseline           *jsonReport
+	failRules          []failRule
+	assertFlags        stringList
+	assertions         []assertion
+	latencyPercentiles []float64
+	timelineInterval   time.Duration
+	histogramMax       time.Duration
+	histogramPrecision int
+	h2cMode            bool
+	http3Mode          bool
+	keyLogFile         string
+	alpnList           string
+	clientCertsPath    string
+	showCert           bool
+	tlsTiming          bool
+	sniName            string
+	logRequests        string
+	logSample          string
+	logSamplePercent   float64
+	jtlFile            string
+	statsdAddress      string
+	statsdPrefix       string
+	statsdTags         string
+	influxURL          string
+	influxToken        string
+	influxInterval     time.Duration
+	otlpEndpoint       string
+	otlpTraces         bool
+	labelFlags         stringList
+	runLabels          map[string]string
+	validatorCmd       string
+	validatorSample    float64
+	validatorRunners   int
+	replayLog          string
+	hashExclude        string
+	curvePreferences   []tls.CurveID
+	failOver           time.Duration
+	dutyOn             time.Duration
+	dutyOff            time.Duration
+	targetRate         float64
+	clientRate         float64
+	stagesSpec         string
+	arrivals           string
+	targetP99          time.Duration
+	summaryInterval    time.Duration
+	statsInterval      time.Duration
+	controlAddress     string
+	stagger            time.Duration
+	totalRequests      int64
+	connections        string
+	burstCount         int
+	vuRate             float64
+	vuSession          int
+	burstInterval      time.Duration
+	maxErrorRate       string
+	maxErrorPercent    float64
+	maxErrors          int64
+	sineMeanRate       float64
+	sineAmplitude      float64
+	sinePeriod         time.Duration
 )
 
 type Configuration struct {
-	urls       []string
-	method     string
-	postData   []byte
-	requests   int64
-	period     int64
-	keepAlive  bool
-	authHeader string
+	urls            []string
+	method          string
+	postData        []byte
+	postDataType    string
+	payloads        []*payload
+	cookies         []*http.Cookie
+	tokens          []string
+	clientCerts     []tls.Certificate
+	userAgents      []string
+	urlTemplates    map[string]*template
+	dataRows        [][]string
+	streamed        *streamedBody
+	requests        int64
+	period          int64
+	keepAlive       bool
+	authHeader      string
+	headers         http.Header
+	trailers        http.Header
+	retries         int
+	idempotencyKeys bool
+	matrixHeader    string
+	matrixValues    []string
+	replay          *replaySchedule
+	personas        []*persona
+	mix             *trafficMix
+	validator       *validator
+	persona         string
+	thinkTime       time.Duration
+	dutyCycle       *dutyCycle
+	pacer           *pacer
+	stages          []stage
+	target          *latencyTarget
+	burst           *burst
+	queue           *workQueue
 
 	myClient *http.Client
 }
@@ -61,17 +218,102 @@ type Result struct {
 	success       int64
 	networkFailed int64
 	badFailed     int64
+	tooSlow       int64
+	retries       int64
+	replayed      int64
+	rateLimited   int64
+
+	// rateLimitDelay is the time spent waiting as told by Retry-After
+	rateLimitDelay time.Duration
+
+	breakerOpened     int64
+	breakerHalfOpened int64
+	breakerClosed     int64
+	breakerOpenTime   time.Duration
+
+	// gzipResponses counts the responses that came gzip encoded, whose
+	// bodies were compressedBytes on the wire and decompressedBytes once
+	// decompressed
+	gzipResponses     int64
+	compressedBytes   int64
+	decompressedBytes int64
+
+	// sessions counts the -vu-rate virtual users that have come and gone
+	sessions int64
 }
 
 type resp struct {
-	status  int
-	latency int64
-	size    int
+	sent     time.Time
+	received time.Time
+	url      string
+	status   int
+	latency  int64
+	complete int64
+	size     int
+	cycle    int
+	tooSlow  bool
+	trailers []string
+	bodyHash uint64
+	method   string
+	persona  string
+	// err is why the request failed when status is 0, and errorKind the
+	// kind of network failure it was
+	err       string
+	errorKind string
+
+	// matrixValue is the -header-matrix value the request carried
+	matrixValue string
+
+	// operation is the -mix operation the request was
+	operation string
+
+	// endpoint is the -u or -f URL the request was made from, before any
+	// template in it was filled in
+	endpoint string
+
+	// corrected is the latency from when a paced request was due to be
+	// sent, which counts the time it waited for a free client, or -1 if it
+	// wasn't paced
+	corrected int64
+
+	// traceID and spanID identify the request's span with -otlp-traces
+	traceID string
+	spanID  string
+
+	// timing is where the time of the request went, with -slowest
+	timing *requestTiming
+
+	// malformed is the kind of malformed request that was sent and outcome
+	// how the server reacted to it
+	malformed string
+	outcome   string
 }
 
+// reportOutput is where -o json and -o csv write the report, the real stdout
+var reportOutput *os.File
+
 var readThroughput int64
 var writeThroughput int64
-var cipherSuiteID uint16
+var cipherSuites []uint16
+var droppedMessages int64
+
+// streamThreshold is the size above which a -d file is streamed from disk
+// rather than read into memory
+const streamThreshold = 16 << 20
+
+// matrixCursor picks the next -header-matrix value
+var matrixCursor uint64
+
+// userAgentCursor picks the -ua-file User-Agent of the next request
+var userAgentCursor uint64
+
+// tokenCursor picks the -tokens-file token of the next client to start
+var tokenCursor uint64
+
+// dumpsRemaining is how many more replies -dump prints
+var dumpsRemaining int64 = 5
+var ipv4Connections int64
+var ipv6Connections int64
 
 type MyConn struct {
 	net.Conn
@@ -97,6 +339,13 @@ func (this *MyConn) Write(b []byte) (n int, err error) {
 	return len, err
 }
 
+func (this *MyConn) Close() error {
+	if tcpInfoSamples != nil {
+		tcpInfoSamples.unregister(this)
+	}
+	return this.Conn.Close()
+}
+
 func checkCipherSuiteName(cipherName string) (bool, uint16) {
 	//takes a string and checks for a match in all names
 	for _, c := range tls.CipherSuites() {
@@ -125,40 +374,183 @@ func printCipherSuiteNames() {
 }
 
 func init() {
-	flag.Int64Var(&requests, "r", -1, "Number of requests per client")
+	flag.Int64Var(&requests, "r", -1, "Number of requests per client. With -t, the run stops at whichever comes first")
+	flag.StringVar(&connections, "conns", "", "Connections to share between the clients, e.g. 100 for 1000 clients over 100 keep-alive connections, or client for one connection per client. By default the pool is as big as -c")
+	flag.Int64Var(&totalRequests, "n", 0, "Exact total number of requests, shared between all clients, which take the URLs in turn. Incompatible with -r")
 	flag.IntVar(&clients, "c", 100, "Number of concurrent clients")
 	flag.StringVar(&targetURL, "u", "", "URL. Incompatible with -f")
 	flag.StringVar(&urlsFilePath, "f", "", "URL's file path (line seperated)")
+	flag.StringVar(&mixSpec, "mix", "", "Weighted blend of operations, e.g. \"GET:/list=80,POST:/create=20\", with results per operation. Paths are added to -u. Only POST, PUT and PATCH send the body. Incompatible with -f")
 	flag.BoolVar(&keepAlive, "k", false, "Do HTTP keep-alive")
+	flag.BoolVar(&compareKeepAlive, "compare-keepalive", false, "Run the workload twice, with and without keep-alive, and compare the two")
 	flag.BoolVar(&insecureSkipVerify, "s", false, "Skip cert check")
 	flag.StringVar(&mtlsCertFile, "x", "", "Certificate for MATLS")
 	flag.StringVar(&mtlsKeyFile, "y", "", "Key to certificate for MATLS")
+	flag.StringVar(&keyPassword, "key-pass", "", "Passphrase for an encrypted -y key, or env:NAME to read it from environment variable NAME")
+	flag.DurationVar(&certReloadInterval, "cert-reload", 0, "Reload the MATLS certificate and key from disk at this interval (they are always reloaded on SIGHUP)")
+	flag.BoolVar(&tlsTiming, "tls-timing", false, "Time the TLS handshakes and report how long they took. Traces every request, which costs a little")
+	flag.BoolVar(&showCert, "show-cert", false, "Print the TLS version, cipher suite and server certificate chain of the first connection")
+	flag.StringVar(&clientCertsPath, "client-certs", "", "Give each client its own MATLS identity from this directory of NAME.crt (or NAME.pem) and NAME.key pairs, or file of CERT KEY lines. Clients take them in turn")
+	flag.StringVar(&pkcs12File, "p12", "", "PKCS#12 (.p12/.pfx) bundle with certificate and key for MATLS. Incompatible with -x and -y")
+	flag.StringVar(&pkcs12Password, "p12-pass", "", "Passphrase for -p12, or env:NAME to read it from environment variable NAME")
 	flag.BoolVar(&trackMaxLatency, "m", false, "Track and report the maximum latency as it occurs")
-	flag.StringVar(&postDataFilePath, "d", "", "HTTP POST data file path")
-	flag.Int64Var(&period, "t", -1, "Period of time (in seconds)")
+	flag.StringVar(&postDataFilePath, "d", "", "File with the request body, sent with the -X method (default POST). Files over 16MiB are streamed from disk for each request, without filling in placeholders")
+	flag.StringVar(&postDataDir, "d-dir", "", "Directory of request bodies, one per file. Each client sends them in turn, starting from a random one")
+	flag.StringVar(&bodySize, "body-size", "", "Send a random body of this size, e.g. 64k, or a size picked for each request from a range, e.g. 1k-1m")
+	flag.BoolVar(&compressBody, "compress-body", false, "Compress the request body with gzip and send it with Content-Encoding: gzip")
+	flag.StringVar(&requestBody, "body", "", "Request body, given inline, sent with the -X method (default POST). Incompatible with -d")
+	flag.Var(&formFields, "form", "Send a multipart/form-data body with this field, as name=value or name=@file to upload a file. May be repeated. Incompatible with -d and -body")
+	flag.Var(&urlencodedFields, "data-urlencode", "Send an application/x-www-form-urlencoded body with this field, as name=value. The value is encoded for you. May be repeated. Incompatible with -d, -body and -form")
+	flag.StringVar(&contentType, "content-type", "", "Content-Type header to send, e.g. application/json")
+	flag.StringVar(&requestMethod, "X", "", "HTTP method to use, e.g. PUT, PATCH, DELETE, HEAD or OPTIONS (default GET, or POST with -d)")
+	flag.Int64Var(&period, "t", -1, "Period of time (in seconds). With -r, the run stops at whichever comes first")
 	flag.IntVar(&writeTimeout, "tw", 5000, "Write timeout (in milliseconds)")
 	flag.IntVar(&readTimeout, "tr", 5000, "Read timeout (in milliseconds)")
-	flag.StringVar(&authHeader, "auth", "", "Authorization header. Incompatible with -f")
+	flag.StringVar(&authHeader, "auth", "", "Authorization header, or env:NAME to read it from environment variable NAME. Incompatible with -f")
+	flag.StringVar(&tokensFile, "tokens-file", "", "File of Authorization header values, one per line. Each client is given its own, in turn. Incompatible with -auth and -basic")
+	flag.StringVar(&basicAuth, "basic", "", "Basic authentication as user:password, or env:NAME to read it from environment variable NAME. Incompatible with -auth")
 	flag.StringVar(&hostHeader, "host", "", "Host header to use (independent of URL). Incompatible with -f")
+	flag.StringVar(&sniName, "sni", "", "Server name to send in the TLS handshake and check the certificate against, independent of -host and -resolve")
 	flag.StringVar(&resolve, "resolve", "", "Resolve. Like -resolve in curl. Used for the CN/SAN match in a cert. Incompatible with -f")
+	flag.Var(&personaFlags, "persona", "Client persona, as name=NAME,share=PERCENT[,keepalive][,think=DURATION][,rate=PER_CLIENT_RPS]. Clients are divided between personas by share and reported per persona. May be repeated")
+	flag.StringVar(&cacheBust, "cache-bust", "", "Add this query parameter with a random value to every request, e.g. _cb, to get past caches. URLs can also contain placeholders such as {{rand}} or {{uuid}}")
+	flag.StringVar(&dataCSV, "data-csv", "", "CSV file, with a header row, whose columns fill {{column}} placeholders in URLs and bodies. Each client works through the rows from a random one")
+	flag.BoolVar(&cookieJar, "cookie-jar", false, "Give each client its own cookie jar, so that cookies the server sets are sent back like a browser would")
+	flag.Var(&cookies, "cookie", "Cookie to send, as name=value. May be repeated")
+	flag.StringVar(&userAgent, "ua", "", "User-Agent header to send instead of Go's")
+	flag.StringVar(&userAgentsFile, "ua-file", "", "File of User-Agent values, one per line, to send in turn with each request. Incompatible with -ua")
+	flag.StringVar(&accept, "accept", "", "Accept header to send, e.g. application/json")
+	flag.BoolVar(&acceptGzip, "gzip", true, "Send Accept-Encoding: gzip and decompress gzip responses. -gzip=false asks for uncompressed responses")
+	flag.StringVar(&headersFile, "headers-file", "", "File of 'Name: value' lines, headers to send with every request. ${NAME} is replaced by environment variable NAME")
+	flag.Var(&requestTrailers, "trailer", "Request trailer to send, as 'Name: value', on methods that carry a body such as POST. May be repeated")
+	flag.IntVar(&maxRetries, "retries", 0, "Number of times to retry a request that fails or gets a 5xx response")
+	flag.BoolVar(&idempotencyKeys, "idempotency-key", false, "Send an Idempotency-Key header that is unique to each request and reused by its retries")
+	flag.BoolVar(&retryAfter, "retry-after", false, "Honour Retry-After on 429 and 503 responses by pausing the client, and count them as rate limited rather than failed")
+	flag.DurationVar(&retryAfterMax, "retry-after-max", time.Minute, "Longest pause to take for a Retry-After header")
+	flag.IntVar(&breakerThreshold, "breaker", 0, "Give each client a circuit breaker that opens after this many consecutive failures or 5xx responses")
+	flag.DurationVar(&breakerCooldown, "breaker-cooldown", 5*time.Second, "Time a circuit breaker stays open before letting a probe request through")
+	flag.StringVar(&replayHeader, "replay-header", "Idempotent-Replayed", "Response header that is 'true' when the server replayed an idempotent request")
+	flag.Float64Var(&malformedPercent, "malformed", 0, "Percentage of requests to replace with malformed ones (oversized headers, odd paths, bad Content-Length...). Reported separately")
 	flag.BoolVar(&dumpResponse, "dump", false, "Dump a bunch of replies")
-	flag.StringVar(&cipherSuite, "cipher", "", "TLS Cipher Suite to use in connection")
+	flag.StringVar(&validatorCmd, "validator-cmd", "", "Command (run with /bin/sh -c) to pipe a sample of response bodies to. It gets GOBENCH_URL, GOBENCH_STATUS and GOBENCH_TRAILERS (Name: value lines) in its environment and exits 0 for a valid response")
+	flag.Float64Var(&validatorSample, "validator-sample", 1, "Percentage of responses to pipe to -validator-cmd")
+	flag.IntVar(&validatorRunners, "validator-runners", 4, "Number of -validator-cmd commands to run at once")
+	flag.StringVar(&logRequests, "log-requests", "", "Write every request, with when it was sent and its outcome, to this file as newline delimited JSON")
+	flag.StringVar(&logSample, "log-sample", "100%", "Percentage of requests to write to -log-requests, e.g. 1% to keep the overhead down at high rates")
+	flag.StringVar(&jtlFile, "jtl", "", "Write every request to this file as a JMeter CSV results (JTL) file, for JMeter's listeners and the tools that read them")
+	flag.StringVar(&statsdAddress, "statsd", "", "Send the latency, status and size of every request to a StatsD/DogStatsD agent at this host:port as the run goes")
+	flag.StringVar(&statsdPrefix, "statsd-prefix", "gobench", "Prefix of the names of the metrics sent to -statsd")
+	flag.StringVar(&statsdTags, "statsd-tags", "", "DogStatsD tags to add to every metric sent to -statsd, as key:value,key:value")
+	flag.StringVar(&influxURL, "influxdb", "", "Write the requests, errors and latency percentiles of every -influxdb-interval to InfluxDB in line protocol at this write URL, e.g. http://localhost:8086/write?db=gobench")
+	flag.StringVar(&influxToken, "influxdb-token", "", "API token for -influxdb, for InfluxDB 2 and later")
+	flag.DurationVar(&influxInterval, "influxdb-interval", 10*time.Second, "Time between the points written to -influxdb")
+	flag.StringVar(&otlpEndpoint, "otlp", "", "Send the metrics of the run to an OpenTelemetry collector over OTLP/HTTP at this endpoint, e.g. http://localhost:4318")
+	flag.BoolVar(&otlpTraces, "otlp-traces", false, "Also send a client span for every request to -otlp, and a traceparent header with the request so that the server's spans join its trace")
+	flag.Var(&labelFlags, "label", "Label the run with key=value, as a tag of the metrics exported to -influxdb and a resource attribute of those sent to -otlp. May be repeated")
+	flag.StringVar(&replayLog, "replay-log", "", "Replay the requests of a -log-requests file with their original timing instead of requesting -u or -f")
+	flag.StringVar(&spectrumFile, "spectrum", "", "Write the latency percentile spectrum in the HdrHistogram/wrk2 text format read by hdrplot to this file, or - for stdout")
+	flag.StringVar(&spectrumFile, "hgrm", "", "Write the HdrHistogram percentile distribution (.hgrm) of the latencies to this file, for HdrHistogram plotting tools. Same as -spectrum")
+	flag.StringVar(&percentiles, "percentiles", "2.5,50,97.5,99", "Comma separated latency percentiles to print, e.g. 50,90,95,99,99.9,99.99")
+	flag.IntVar(&slowest, "slowest", 0, "Keep the N slowest requests and list them at the end with their URL, status, when they were sent and where the time went")
+	flag.BoolVar(&throughputChart, "chart", false, "Plot the requests per second over the run in the terminal at the end")
+	flag.BoolVar(&printSizes, "sizes", false, "Print the distribution of response sizes, overall and per status class, to spot truncated responses or error pages")
+	flag.StringVar(&timelineFile, "timeline", "", "Write the throughput and latency percentiles of every -timeline-interval of the run to this file, as JSON if it ends in .json and CSV otherwise")
+	flag.BoolVar(&http3Mode, "http3", false, "Send the requests over HTTP/3 (QUIC) and report the QUIC handshake time and 0-RTT use")
+	flag.BoolVar(&h2cMode, "http2-prior-knowledge", false, "Speak HTTP/2 without TLS (h2c) to http:// URLs, with no upgrade from HTTP/1.1")
+	flag.DurationVar(&histogramMax, "histogram-max", 10*time.Second, "Longest latency the histograms track. Longer ones are counted as this long")
+	flag.IntVar(&histogramPrecision, "histogram-precision", 5, "Significant figures (1 to 5) the run's latency histograms keep")
+	flag.DurationVar(&timelineInterval, "timeline-interval", time.Second, "Length of the intervals written to -timeline")
+	flag.BoolVar(&showProgress, "progress", true, "Show how far the run has got, and how long it has left, while it runs. Only when stdout is a terminal")
+	flag.BoolVar(&quiet, "q", false, "Quiet: print nothing but a one line summary of the run")
+	flag.StringVar(&outputFormat, "o", "text", "Output format: text, json to write the -report-json report to stdout, csv for a one row summary with a header, or wrk for a summary laid out like wrk's, with everything else going to stderr")
+	flag.StringVar(&appendCSVFile, "append-csv", "", "Append a one row CSV summary of the run to this file, for a trend over many runs. The header row is written when the file is new")
+	flag.StringVar(&reportHTML, "report-html", "", "Write a single file HTML report of the run, with latency and throughput charts and a breakdown of status codes, to this file")
+	flag.StringVar(&reportJSON, "report-json", "", "Write a versioned JSON report of the run, including its configuration, to this file")
+	flag.Var(&assertFlags, "assert", "Exit non-zero if the run misses this threshold, e.g. \"p99<100ms\", \"error_rate<1%\" or \"rps>=500\". Metrics are percentiles such as p99, mean, min, max, rps, error_rate, errors and requests. May be repeated")
+	flag.StringVar(&baselineFile, "baseline", "", "Compare the run with the results saved by -save in this file, and exit non-zero if it breaks a -fail-on rule")
+	flag.StringVar(&failOn, "fail-on", "", "Comma separated limits on how much the run may be worse than -baseline, e.g. \"p99>+10%,rps<-5%,errors>+50%\". Metrics are rps, errors (the error rate), mean, max and p50, p99 and the other percentiles of the report")
+	flag.StringVar(&reportJSON, "save", "", "Save the results of the run to this file, to compare with another run with gobench compare. Same as -report-json")
+	flag.BoolVar(&printConfiguration, "print-config", false, "Print the resolved configuration (secrets redacted) before starting")
+	flag.BoolVar(&dryRun, "dry-run", false, "Print the resolved configuration and the first few requests that would be sent, then exit")
+	flag.StringVar(&keyLogFile, "keylog", "", "Append the TLS secrets of every connection to this file in NSS key log format, for Wireshark to decrypt captured traffic")
+	flag.StringVar(&cipherSuite, "cipher", "", "Comma separated TLS cipher suites to offer, or the presets FIPS, MODERN and LEGACY. Only applies up to TLS 1.2")
+	flag.DurationVar(&fallbackDelay, "fallback-delay", 0, "Happy Eyeballs: how long to wait for IPv6 before also trying IPv4 (0 for Go's default of 300ms, negative to disable)")
+	flag.StringVar(&ipFamily, "ip", "", "Only connect over IPv4 (4) or IPv6 (6)")
+	flag.DurationVar(&tcpInfoInterval, "tcp-info", 0, "Sample TCP_INFO (RTT, retransmits, congestion window) from open connections at this interval and report it (Linux only)")
+	flag.StringVar(&alpnList, "alpn", "", "Comma separated ALPN protocols to offer in the TLS handshake, e.g. http/1.1 to keep to HTTP/1.1 when the server offers h2, or h2,http/1.1 to use HTTP/2")
+	flag.StringVar(&curveList, "curves", "", "Comma separated key exchange groups to offer, in order of preference (e.g. X25519MLKEM768,X25519,P-256)")
+	flag.StringVar(&echConfig, "ech", "", "Encrypted Client Hello config list to offer: base64, @file or dns to look it up in the HTTPS record of -u")
+	flag.BoolVar(&tlsResume, "resume", false, "Cache TLS sessions and resume them on new connections")
+	flag.DurationVar(&failOver, "fail-over", 0, "Count 2xx responses slower than this as failures (too slow) rather than successes")
+	flag.DurationVar(&driftWindow, "drift-window", 0, "Watch for latency drift: fit a trend through the p99 of windows of this length")
+	flag.Float64Var(&driftThreshold, "drift-threshold", 20, "Alert when the p99 trend has risen by this percentage")
+	flag.StringVar(&driftWebhook, "drift-webhook", "", "URL to POST a JSON latency drift alert to")
+	flag.StringVar(&headerMatrix, "header-matrix", "", "Header to cycle through a set of values with per value results, as Name=value1,value2,... or Name=@file with one value per line")
+	flag.BoolVar(&hashBodies, "hash-bodies", false, "Hash response bodies per URL and report when they change during the run")
+	flag.StringVar(&hashExclude, "hash-exclude", "", "Regular expression matching the -u or -f URLs whose bodies are expected to change, for -hash-bodies. Bodies are compared per -u or -f URL, before -cache-bust or placeholders are filled in")
+	flag.DurationVar(&dutyOn, "duty-on", 0, "Duty cycle: time to send at full rate before going idle for -duty-off")
+	flag.DurationVar(&dutyOff, "duty-off", 0, "Duty cycle: time to stay idle between -duty-on periods")
+	flag.Float64Var(&targetRate, "rps", 0, "Constant load: requests per second across all clients, rather than each client going as fast as it can. Incompatible with -sine-rate")
+	flag.Float64Var(&clientRate, "rps-per-client", 0, "Throttle each client on its own to this many requests per second, like many slow clients. Incompatible with -rps, -stages, -sine-rate, -target-p99 and -burst")
+	flag.StringVar(&stagesSpec, "stages", "", "Staged load: phases of DURATION:RATE, e.g. \"1m:100rps,2m:500rps,1m:0\", with results per stage. The run lasts as long as the stages unless -t or -r is given. Incompatible with -rps and -sine-rate")
+	flag.StringVar(&arrivals, "arrivals", "uniform", "How requests are spaced at the rate of -rps, -stages, -sine-rate or -persona rate: uniform, or poisson for the random arrivals of an open model. -c is then the most requests in flight at once")
+	flag.DurationVar(&targetP99, "target-p99", 0, "Adjust the rate every 2s to find the most load that keeps the 99th percentile latency within this, starting from -rps (default 10). Incompatible with -stages and -sine-rate")
+	flag.DurationVar(&stagger, "stagger", 0, "Delay each client's first request by a random time up to this, e.g. 10ms, so that clients don't send in lockstep")
+	flag.StringVar(&controlAddress, "control", "", "Listen on unix:PATH or HOST:PORT for commands that change the number of clients while the run is going: clients, clients N, +N and -N, one a line")
+	flag.DurationVar(&summaryInterval, "summary-interval", 0, "Print a summary of the last interval and the run so far this often, e.g. 10m for a long soak test. With -drift-window the p99 trend is included")
+	flag.DurationVar(&statsInterval, "interval", 0, "Print a compact line with the requests, errors, rate and p50 and p99 latency of the last interval this often, e.g. 10s. Incompatible with -summary-interval, which adds the run so far")
+	flag.Float64Var(&vuRate, "vu-rate", 0, "Virtual users: start this many users a second, each with its own connections, sending -vu-session requests and then leaving. -c is the most users at once. Needs -t")
+	flag.IntVar(&vuSession, "vu-session", 10, "Virtual users: number of requests each -vu-rate user sends")
+	flag.IntVar(&burstCount, "burst", 0, "Burst mode: send this many requests across all clients as fast as they can at the start of every -burst-interval, then pause, with results per burst")
+	flag.DurationVar(&burstInterval, "burst-interval", time.Second, "Burst mode: time from the start of one -burst to the next")
+	flag.StringVar(&maxErrorRate, "max-error-rate", "", "Stop the run and exit non-zero once more than this percentage of responses, e.g. 5%, are errors (network failures, !2xx and too slow). Checked from the 100th response")
+	flag.Int64Var(&maxErrors, "max-errors", 0, "Stop the run and exit non-zero once this many responses are errors (network failures, !2xx and too slow)")
+	flag.Float64Var(&sineMeanRate, "sine-rate", 0, "Sinusoidal load: mean requests per second across all clients")
+	flag.Float64Var(&sineAmplitude, "sine-amplitude", 0.5, "Sinusoidal load: swing either side of -sine-rate as a fraction of it (0-1)")
+	flag.DurationVar(&sinePeriod, "sine-period", 10*time.Minute, "Sinusoidal load: time for one full swing of the rate")
 }
 
-func printResults(results map[int]*Result, startTime time.Time) {
-	var requests int64
-	var success int64
-	var networkFailed int64
-	var badFailed int64
-
+// total adds up the results of all clients
+func total(results map[int]*Result) Result {
+	var sum Result
 	for _, result := range results {
-		requests += result.requests
-		success += result.success
-		networkFailed += result.networkFailed
-		badFailed += result.badFailed
+		sum.add(result)
 	}
+	return sum
+}
+
+// add adds other to the result
+func (r *Result) add(other *Result) {
+	r.requests += other.requests
+	r.success += other.success
+	r.networkFailed += other.networkFailed
+	r.badFailed += other.badFailed
+	r.tooSlow += other.tooSlow
+	r.retries += other.retries
+	r.replayed += other.replayed
+	r.rateLimited += other.rateLimited
+	r.rateLimitDelay += other.rateLimitDelay
+	r.breakerOpened += other.breakerOpened
+	r.breakerHalfOpened += other.breakerHalfOpened
+	r.breakerClosed += other.breakerClosed
+	r.breakerOpenTime += other.breakerOpenTime
+	r.gzipResponses += other.gzipResponses
+	r.compressedBytes += other.compressedBytes
+	r.decompressedBytes += other.decompressedBytes
+	r.sessions += other.sessions
+}
+
+func printResults(results map[int]*Result, elapsedTime time.Duration) {
+	sum := total(results)
+	requests := sum.requests
+	success := sum.success
+	networkFailed := sum.networkFailed
+	badFailed := sum.badFailed
+	tooSlow := sum.tooSlow
+	retries := sum.retries
+	replayed := sum.replayed
 
-	elapsed := float32(time.Since(startTime).Milliseconds())
+	elapsed := float32(elapsedTime.Milliseconds())
 
 	if elapsed == 0.0 {
 		elapsed = 1.0
@@ -169,53 +561,113 @@ func printResults(results map[int]*Result, startTime time.Time) {
 	fmt.Printf("Successful requests:            %10d hits\n", success)
 	fmt.Printf("Network failed:                 %10d hits\n", networkFailed)
 	fmt.Printf("Bad requests failed (!2xx):     %10d hits\n", badFailed)
+	if failOver > 0 {
+		fmt.Printf("%-32s%10d hits\n", fmt.Sprintf("Too slow (2xx over %v):", failOver), tooSlow)
+	}
+	if maxRetries > 0 {
+		fmt.Printf("Retries:                        %10d hits\n", retries)
+	}
+	if idempotencyKeys {
+		fmt.Printf("Replayed (idempotent):          %10d hits\n", replayed)
+	}
+	if retryAfter {
+		fmt.Printf("Rate limited (429/503):         %10d hits\n", sum.rateLimited)
+		fmt.Printf("Retry-After delay:              %10.2f sec\n", sum.rateLimitDelay.Seconds())
+	}
+	if breakerThreshold > 0 {
+		printBreaker(sum)
+	}
+	if sum.gzipResponses > 0 {
+		printCompression(sum)
+	}
+	if vuRate > 0 {
+		fmt.Printf("Virtual user sessions:          %10d\n", sum.sessions)
+		fmt.Printf("Virtual users delayed (-c):     %10d\n", atomic.LoadInt64(&delayedArrivals))
+	}
 	fmt.Printf("Successful requests rate:       %10.0f hits/sec\n", float32(success)/(elapsed/1000.0))
-	fmt.Printf("Read throughput:                %10.0f bytes/sec\n", float32(readThroughput)/(elapsed/1000.0))
-	fmt.Printf("Write throughput:               %10.0f bytes/sec\n", float32(writeThroughput)/(elapsed/1000.0))
+	fmt.Printf("Read throughput:                %10.0f bytes/sec\n", float32(atomic.LoadInt64(&readThroughput))/(elapsed/1000.0))
+	fmt.Printf("Write throughput:               %10.0f bytes/sec\n", float32(atomic.LoadInt64(&writeThroughput))/(elapsed/1000.0))
+	fmt.Printf("Connections (IPv4):             %10d\n", atomic.LoadInt64(&ipv4Connections))
+	if atomic.LoadInt64(&ipv6Connections) > 0 {
+		fmt.Printf("Connections (IPv6):             %10d\n", atomic.LoadInt64(&ipv6Connections))
+	}
+	if atomic.LoadInt64(&fullHandshakes)+atomic.LoadInt64(&resumedHandshakes) > 0 {
+		fmt.Printf("TLS handshakes (full):          %10d\n", atomic.LoadInt64(&fullHandshakes))
+		fmt.Printf("TLS handshakes (resumed):       %10d\n", atomic.LoadInt64(&resumedHandshakes))
+	}
+	printTLSHandshakes()
+	printQUICHandshakes()
+	printCurves()
+	printProtocols()
+	if echConfig != "" {
+		fmt.Printf("ECH accepted:                   %10d\n", atomic.LoadInt64(&echAccepted))
+		fmt.Printf("ECH rejected:                   %10d\n", atomic.LoadInt64(&echRejected))
+	}
 	fmt.Printf("Test time:                      %10.2f sec\n", (elapsed / 1000.0))
 }
 
-func printLatency(latencies *hdrhistogram.Histogram) {
+func printCompression(sum Result) {
+	fmt.Printf("Gzip responses:                 %10d hits\n", sum.gzipResponses)
+	fmt.Printf("Body bytes (compressed):        %10d bytes\n", sum.compressedBytes)
+	fmt.Printf("Body bytes (decompressed):      %10d bytes\n", sum.decompressedBytes)
+	if sum.decompressedBytes > 0 {
+		fmt.Printf("Compression ratio:              %10.2f\n", float64(sum.compressedBytes)/float64(sum.decompressedBytes))
+	}
+}
+
+// printLatency prints the latency statistics, those corrected for
+// coordinated omission if there are any and those of the responses that
+// weren't 2xx and the requests that failed
+func printLatency(c *collector) {
 
 	fmt.Println("")
 	shortLatency := tablewriter.NewWriter(os.Stdout)
 	shortLatency.SetRowSeparator("-")
-	shortLatency.SetHeader([]string{
-		"Stat",
-		"2.5%",
-		"50%",
-		"97.5%",
-		"99%",
-		"Avg",
-		"Stdev",
-		"Min",
-		"Max",
-	})
-	shortLatency.SetHeaderColor(tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor},
-		tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor},
-		tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor},
-		tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor},
-		tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor},
-		tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor},
-		tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor},
-		tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor},
-		tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor})
-	shortLatency.Append([]string{
-		chalk.Bold.TextStyle("Latency"),
-		fmt.Sprintf("%v ms", latencies.ValueAtPercentile(2.5)),
-		fmt.Sprintf("%v ms", latencies.ValueAtPercentile(50)),
-		fmt.Sprintf("%v ms", latencies.ValueAtPercentile(97.5)),
-		fmt.Sprintf("%v ms", latencies.ValueAtPercentile(99)),
-		fmt.Sprintf("%.2f ms", latencies.Mean()),
-		fmt.Sprintf("%.2f ms", latencies.StdDev()),
-		fmt.Sprintf("%v ms", latencies.Min()),
-		fmt.Sprintf("%v ms", latencies.Max()),
-	})
+	header := []string{"Stat"}
+	for _, percentile := range latencyPercentiles {
+		header = append(header, strconv.FormatFloat(percentile, 'f', -1, 64)+"%")
+	}
+	header = append(header, "Avg", "Stdev", "Min", "Max")
+	shortLatency.SetHeader(header)
+	if colorOutput {
+		colors := make([]tablewriter.Colors, len(header))
+		for i := range colors {
+			colors[i] = tablewriter.Colors{tablewriter.Bold, tablewriter.FgCyanColor}
+		}
+		shortLatency.SetHeaderColor(colors...)
+	}
+	shortLatency.Append(latencyRow("Latency", c.latencies))
+	shortLatency.Append(latencyRow("Latency (with body)", c.fullLatencies))
+	if c.corrected != nil {
+		shortLatency.Append(latencyRow("Latency (corrected)", c.corrected))
+	}
+	if c.non2xxLatencies != nil {
+		shortLatency.Append(latencyRow("Latency (non-2xx)", c.non2xxLatencies))
+	}
+	if c.failedLatencies != nil {
+		shortLatency.Append(latencyRow("Latency (failed)", c.failedLatencies))
+	}
+	if c.slowLatencies != nil {
+		shortLatency.Append(latencyRow("Latency (too slow)", c.slowLatencies))
+	}
 	shortLatency.Render()
 	fmt.Println("")
 
 }
 
+func latencyRow(name string, latencies *hdrhistogram.Histogram) []string {
+	row := []string{bold(name)}
+	for _, percentile := range latencyPercentiles {
+		row = append(row, formatLatency(latencies.ValueAtPercentile(percentile)))
+	}
+	return append(row,
+		fmt.Sprintf("%.2f ms", latencies.Mean()/1000),
+		fmt.Sprintf("%.2f ms", latencies.StdDev()/1000),
+		formatLatency(latencies.Min()),
+		formatLatency(latencies.Max()),
+	)
+}
+
 func readLines(path string) (lines []string, err error) {
 
 	var file *os.File
@@ -247,323 +699,1804 @@ func readLines(path string) (lines []string, err error) {
 
 func NewConfiguration() *Configuration {
 
-	if urlsFilePath == "" && targetURL == "" {
+	if urlsFilePath == "" && targetURL == "" && replayLog == "" && mixSpec == "" {
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	if urlsFilePath != "" && (hostHeader != "" || targetURL != "" || authHeader != "" || resolve != "") {
+	bodies := 0
+	for _, given := range []bool{postDataFilePath != "", postDataDir != "", bodySize != "", requestBody != "", len(formFields) > 0, len(urlencodedFields) > 0} {
+		if given {
+			bodies++
+		}
+	}
+	if bodies > 1 {
+		fmt.Println("Only one should be provided: [d|d-dir|body-size|body|form|data-urlencode]")
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	if requests == -1 && period == -1 {
-		fmt.Println("Requests or period must be provided")
+	if userAgent != "" && userAgentsFile != "" {
+		fmt.Println("Only one should be provided: [ua|ua-file]")
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	if requests != -1 && period != -1 {
-		fmt.Println("Only one should be provided: [requests|period]")
+	if (authHeader != "" && basicAuth != "") || (tokensFile != "" && (authHeader != "" || basicAuth != "")) {
+		fmt.Println("Only one should be provided: [auth|basic|tokens-file]")
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	if (mtlsKeyFile != "" && mtlsCertFile == "") || (mtlsKeyFile == "" && mtlsCertFile != "") {
-		fmt.Println("Both cert and key must be specified if one is")
+	if urlsFilePath != "" && (hostHeader != "" || targetURL != "" || authHeader != "" || resolve != "") {
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	configuration := &Configuration{
-		urls:       make([]string, 0),
-		method:     "GET",
-		postData:   nil,
-		keepAlive:  keepAlive,
-		requests:   int64((1 << 63) - 1),
-		authHeader: authHeader}
+	var stages []stage
+	if stagesSpec != "" {
+		var err error
+		if stages, err = parseStages(stagesSpec); err != nil {
+			fmt.Println("Error in -stages:", err)
+			flag.Usage()
+			os.Exit(1)
+		}
+		if targetRate > 0 || sineMeanRate > 0 {
+			fmt.Println("Only one should be provided: [stages|rps|sine-rate]")
+			flag.Usage()
+			os.Exit(1)
+		}
+		if requests == -1 && period == -1 {
+			period = int64((stagesLength(stages) + time.Second - 1) / time.Second)
+		}
+	}
 
-	if period != -1 {
-		configuration.period = period
+	if n, err := strconv.Atoi(connections); connections != "" && connections != "client" && (err != nil || n < 1) {
+		fmt.Println("-conns must be a number of connections or client")
+		flag.Usage()
+		os.Exit(1)
+	}
 
-		timeout := make(chan bool, 1)
-		go func() {
-			<-time.After(time.Duration(period) * time.Second)
-			timeout <- true
-		}()
-
-		go func() {
-			<-timeout
-			pid := os.Getpid()
-			proc, _ := os.FindProcess(pid)
-			err := proc.Signal(os.Interrupt)
-			if err != nil {
-				log.Println(err)
-				return
-			}
-		}()
+	if totalRequests < 0 || (totalRequests > 0 && (requests != -1 || replayLog != "" || vuRate > 0)) {
+		fmt.Println("-n must be positive and is incompatible with -r, -replay-log and -vu-rate")
+		flag.Usage()
+		os.Exit(1)
 	}
 
-	if requests != -1 {
-		configuration.requests = requests
+	if requests == -1 && period == -1 && replayLog == "" && totalRequests == 0 {
+		fmt.Println("Requests or period must be provided")
+		flag.Usage()
+		os.Exit(1)
 	}
 
-	if urlsFilePath != "" {
-		fileLines, err := readLines(urlsFilePath)
+	if (mtlsKeyFile != "" && mtlsCertFile == "") || (mtlsKeyFile == "" && mtlsCertFile != "") {
+		fmt.Println("Both cert and key must be specified if one is")
+		flag.Usage()
+		os.Exit(1)
+	}
 
-		if err != nil {
-			log.Fatalf("Error in ioutil.ReadFile for file: %s Error: %s", urlsFilePath, err)
+	if ipFamily != "" && ipFamily != "4" && ipFamily != "6" {
+		fmt.Println("-ip must be 4 or 6")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if hashExclude != "" {
+		var err error
+		if hashExcluded, err = regexp.Compile(hashExclude); err != nil {
+			fmt.Println("Error in -hash-exclude:", err)
+			os.Exit(1)
 		}
+	}
 
-		configuration.urls = fileLines
+	if tcpInfoInterval > 0 {
+		if !tcpInfoSupported {
+			fmt.Println("-tcp-info is only supported on Linux")
+			os.Exit(1)
+		}
+		tcpInfoSamples = newTCPInfoSampler(tcpInfoInterval)
 	}
 
-	dialer := MyDialer()
-	dialFunction := func(network string, addr string) (net.Conn, error) {
-		return dialer(targetURL)
+	if pkcs12File != "" && mtlsCertFile != "" {
+		fmt.Println("Only one should be provided: [-p12|-x and -y]")
+		flag.Usage()
+		os.Exit(1)
 	}
 
-	certificateExpectedName := parseHostname(targetURL)
-	if resolve != "" {
-		certificateExpectedName = resolve
+	if clientCertsPath != "" && (pkcs12File != "" || mtlsCertFile != "" || http3Mode) {
+		fmt.Println("-client-certs can't be used with -p12, -x and -y or -http3")
+		flag.Usage()
+		os.Exit(1)
 	}
 
-	var cert tls.Certificate
-	var err error
-	if mtlsCertFile != "" {
-		cert, err = tls.LoadX509KeyPair(mtlsCertFile, mtlsKeyFile)
-		if err != nil {
-			log.Fatal(err)
-		}
-	} else {
-		cert = tls.Certificate{}
+	if (dutyOn > 0) != (dutyOff > 0) {
+		fmt.Println("Both -duty-on and -duty-off must be specified if one is")
+		flag.Usage()
+		os.Exit(1)
 	}
 
-	var cipherSuites []uint16
-	if cipherSuite != "" {
-		cipherSuites = append(cipherSuites, cipherSuiteID)
+	if sineMeanRate < 0 || sineAmplitude < 0 || sineAmplitude > 1 || sinePeriod <= 0 {
+		fmt.Println("-sine-rate must not be negative, -sine-amplitude must be between 0 and 1 and -sine-period must be positive")
+		flag.Usage()
+		os.Exit(1)
 	}
 
-	configuration.myClient = &http.Client{
-		Transport: &http.Transport{
-			Dial:                dialFunction,
-			MaxIdleConnsPerHost: clients,
-			MaxIdleConns:        clients,
-			DisableKeepAlives:   !configuration.keepAlive,
-			TLSClientConfig: &tls.Config{
-				ServerName:         certificateExpectedName,
-				InsecureSkipVerify: insecureSkipVerify,
-				Certificates:       []tls.Certificate{cert},
-				CipherSuites:       cipherSuites,
-			},
-		},
+	var err error
+	logSamplePercent, err = strconv.ParseFloat(strings.TrimSuffix(logSample, "%"), 64)
+	if err != nil || logSamplePercent <= 0 || logSamplePercent > 100 {
+		fmt.Println("-log-sample must be a percentage between 0 and 100, e.g. 1%")
+		flag.Usage()
+		os.Exit(1)
 	}
 
-	if targetURL != "" {
-		configuration.urls = append(configuration.urls, targetURL)
+	if runLabels, err = parseLabels(labelFlags); err != nil {
+		fmt.Println(err)
+		flag.Usage()
+		os.Exit(1)
 	}
 
-	if postDataFilePath != "" {
-		configuration.method = "POST"
+	if otlpTraces && otlpEndpoint == "" {
+		fmt.Println("-otlp-traces needs -otlp")
+		flag.Usage()
+		os.Exit(1)
+	}
 
-		data, err := ioutil.ReadFile(postDataFilePath)
+	latencyPercentiles = nil
+	for _, field := range strings.Split(percentiles, ",") {
+		percentile, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(field), "%"), 64)
+		if err != nil || percentile < 0 || percentile > 100 {
+			fmt.Println("-percentiles must be a comma separated list of percentages between 0 and 100, e.g. 50,90,99.9")
+			flag.Usage()
+			os.Exit(1)
+		}
+		latencyPercentiles = append(latencyPercentiles, percentile)
+	}
 
+	assertions = nil
+	for _, spec := range assertFlags {
+		a, err := parseAssertion(spec)
 		if err != nil {
-			log.Fatalf("Error in ioutil.ReadFile for file path: %s Error: %s", postDataFilePath, err)
+			fmt.Println(err)
+			flag.Usage()
+			os.Exit(1)
 		}
+		assertions = append(assertions, a)
+	}
 
-		configuration.postData = data
+	if failOn != "" {
+		if baselineFile == "" {
+			fmt.Println("-fail-on needs -baseline")
+			flag.Usage()
+			os.Exit(1)
+		}
+		if failRules, err = parseFailOn(failOn); err != nil {
+			fmt.Println(err)
+			flag.Usage()
+			os.Exit(1)
+		}
+	}
+	if baselineFile != "" {
+		if baseline, err = readReport(baselineFile); err != nil {
+			log.Fatalf("Error reading baseline %s: %s", baselineFile, err)
+		}
 	}
 
-	configuration.myClient.Timeout = time.Duration(readTimeout) * time.Millisecond
+	// These need connections of their own, which the HTTP/3 transport can't
+	// be cloned to give
+	if http3Mode && (h2cMode || malformedPercent > 0 || compareKeepAlive || connections == "client" || len(personaFlags) > 0 || vuRate > 0) {
+		fmt.Println("-http3 can't be used with -http2-prior-knowledge, -malformed, -compare-keepalive, -conns client, -persona or -vu-rate")
+		flag.Usage()
+		os.Exit(1)
+	}
 
-	return configuration
-}
+	if statsInterval > 0 && summaryInterval > 0 {
+		fmt.Println("-interval can't be used with -summary-interval")
+		flag.Usage()
+		os.Exit(1)
+	}
 
-func parseHostname(address string) string {
-	u, err := url.Parse(address)
-	if err != nil {
-		log.Fatal(err)
+	if http3Mode && alpnList != "" {
+		fmt.Println("-alpn can't be used with -http3, which always offers h3")
+		flag.Usage()
+		os.Exit(1)
 	}
-	return u.Host
-}
 
-func parseAddress(address string) string {
-	u, err := url.Parse(address)
-	if err != nil {
-		log.Fatal(err)
+	if histogramMax < time.Millisecond {
+		fmt.Println("-histogram-max must be at least 1ms")
+		flag.Usage()
+		os.Exit(1)
 	}
-	if "" == u.Port() {
-		switch scheme := u.Scheme; scheme {
-		case "https":
-			u.Host = u.Host + ":443"
-		case "http":
-			u.Host = u.Host + ":80"
-		default:
-			log.Fatal("Unable to decode scheme ", u.Scheme)
-		}
+
+	if histogramPrecision < 1 || histogramPrecision > 5 {
+		fmt.Println("-histogram-precision must be between 1 and 5")
+		flag.Usage()
+		os.Exit(1)
 	}
-	return u.Host
-}
 
-func MyDialer() func(address string) (conn net.Conn, err error) {
-	return func(address string) (net.Conn, error) {
-		address = parseAddress(address)
-		conn, err := net.Dial("tcp", address)
-		if err != nil {
-			return nil, err
+	if timelineFile != "" && timelineInterval <= 0 {
+		fmt.Println("-timeline-interval must be positive")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if influxURL != "" && influxInterval <= 0 {
+		fmt.Println("-influxdb-interval must be positive")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if maxErrorRate != "" {
+		var err error
+		maxErrorPercent, err = strconv.ParseFloat(strings.TrimSuffix(maxErrorRate, "%"), 64)
+		if err != nil || maxErrorPercent <= 0 || maxErrorPercent > 100 {
+			fmt.Println("-max-error-rate must be a percentage between 0 and 100, e.g. 5%")
+			flag.Usage()
+			os.Exit(1)
 		}
+	}
 
-		myConn := &MyConn{Conn: conn}
+	if arrivals != "uniform" && arrivals != "poisson" {
+		fmt.Println("-arrivals must be uniform or poisson")
+		flag.Usage()
+		os.Exit(1)
+	}
 
-		return myConn, nil
+	if clientRate < 0 || (clientRate > 0 && (targetRate > 0 || stagesSpec != "" || sineMeanRate > 0 || targetP99 > 0 || burstCount > 0)) {
+		fmt.Println("-rps-per-client must not be negative and is incompatible with -rps, -stages, -sine-rate, -target-p99 and -burst")
+		flag.Usage()
+		os.Exit(1)
 	}
-}
 
-func client(configuration *Configuration, result *Result, errChan chan error, respChan chan *resp, dumpChan chan string, exitChan chan bool) {
+	if targetRate < 0 || (targetRate > 0 && sineMeanRate > 0) {
+		fmt.Println("-rps must not be negative and only one should be provided: [rps|sine-rate]")
+		flag.Usage()
+		os.Exit(1)
+	}
 
-	var size int
-	var statusCode int
-	for result.requests < configuration.requests {
-		for _, tmpUrl := range configuration.urls {
-
-			req, err := http.NewRequest(configuration.method, tmpUrl, nil)
-			// req.Close is true when keep alives are off. But also set in Transport which seems to do the work
-			req.Close = !configuration.keepAlive
-			if len(configuration.authHeader) > 0 {
-				req.Header.Set("Authorization", configuration.authHeader)
+	configuration := &Configuration{
+		urls:            make([]string, 0),
+		method:          "GET",
+		postData:        nil,
+		keepAlive:       keepAlive,
+		requests:        int64((1 << 63) - 1),
+		authHeader:      secret(authHeader),
+		retries:         maxRetries,
+		idempotencyKeys: idempotencyKeys}
+
+	if headersFile != "" {
+		lines, err := readLines(headersFile)
+		if err != nil {
+			log.Fatalf("Error in ioutil.ReadFile for file: %s Error: %s", headersFile, err)
+		}
+		configuration.headers = make(http.Header)
+		for _, line := range lines {
+			if line = strings.TrimSpace(line); line == "" || strings.HasPrefix(line, "#") {
+				continue
 			}
-			if &hostHeader != nil {
-				req.Host = hostHeader
+			name, value, ok := strings.Cut(expandEnv(line), ":")
+			if !ok || strings.TrimSpace(name) == "" {
+				log.Fatalf("Error in %s: headers must be given as 'Name: value': %s", headersFile, line)
 			}
+			configuration.headers.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+		}
+	}
 
-			requestStartTime := time.Now()
-			res, err := configuration.myClient.Do(req)
-			requestReplyTime := time.Now()
-			elapsed := int64(requestReplyTime.Sub(requestStartTime) / time.Millisecond)
+	for _, trailer := range requestTrailers {
+		name, value, ok := strings.Cut(trailer, ":")
+		if !ok {
+			fmt.Println("Trailers must be given as 'Name: value':", trailer)
+			flag.Usage()
+			os.Exit(1)
+		}
+		if configuration.trailers == nil {
+			configuration.trailers = make(http.Header)
+		}
+		configuration.trailers.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
 
+	if headerMatrix != "" {
+		name, values, ok := strings.Cut(headerMatrix, "=")
+		if !ok || name == "" {
+			fmt.Println("-header-matrix must be given as Name=value1,value2,... or Name=@file")
+			flag.Usage()
+			os.Exit(1)
+		}
+		if strings.HasPrefix(values, "@") {
+			lines, err := readLines(values[1:])
 			if err != nil {
-				errChan <- err
-				respChan <- &resp{
-					status:  0,
-					latency: elapsed,
-					size:    0,
-				}
-				statusCode = 0
-			} else {
-				body, _ := ioutil.ReadAll(res.Body)
-				res.Body.Close()
-				if dumpResponse {
-					dumpChan <- string(body)
-				}
-				size = len(body) + 2
-				for key, value := range res.Header {
-					for _, s := range value {
-						size += len(s) + 2
-					}
-					size += len(key) + 2
-				}
-				respChan <- &resp{
-					status:  res.StatusCode,
-					latency: elapsed,
-					size:    size,
-				}
-				statusCode = res.StatusCode
+				log.Fatalf("Error in ioutil.ReadFile for file: %s Error: %s", values[1:], err)
 			}
-			result.requests++
-
-			if err != nil {
-				result.networkFailed++
-				continue
+			for _, line := range lines {
+				if line = strings.TrimSpace(line); line != "" {
+					configuration.matrixValues = append(configuration.matrixValues, line)
+				}
 			}
+		} else {
+			configuration.matrixValues = strings.Split(values, ",")
+		}
+		configuration.matrixHeader = strings.TrimSpace(name)
+	}
 
-			if statusCode >= 200 && statusCode < 300 {
-				result.success++
-			} else {
-				result.badFailed++
-			}
+	if dutyOn > 0 {
+		configuration.dutyCycle = &dutyCycle{
+			start: time.Now(),
+			on:    dutyOn,
+			off:   dutyOff,
 		}
 	}
 
-	exitChan <- true
-}
+	if targetRate > 0 {
+		configuration.pacer = newPacer(func(time.Duration) float64 { return targetRate })
+	}
 
-func main() {
+	if burstCount != 0 {
+		if burstCount < 0 || burstInterval <= 0 {
+			fmt.Println("-burst and -burst-interval must be positive")
+			flag.Usage()
+			os.Exit(1)
+		}
+		if configuration.dutyCycle != nil || targetRate > 0 || stages != nil || sineMeanRate > 0 || targetP99 > 0 {
+			fmt.Println("-burst is incompatible with -duty-on, -rps, -stages, -sine-rate and -target-p99")
+			flag.Usage()
+			os.Exit(1)
+		}
+		configuration.burst = &burst{count: burstCount, interval: burstInterval}
+	}
 
-	startTime := time.Now()
-	var dumpCount = 5
-	var runningGoroutines int
-	var maxLatency = int64(-1)
-	var messageCount = int64(0)
-	var ok bool
-	results := make(map[int]*Result)
-	latencies := hdrhistogram.New(1, 10000, 5)
+	if vuRate != 0 {
+		if vuRate < 0 || vuSession < 1 {
+			fmt.Println("-vu-rate and -vu-session must be positive")
+			flag.Usage()
+			os.Exit(1)
+		}
+		if requests != -1 || replayLog != "" || len(personaFlags) > 0 {
+			fmt.Println("-vu-rate is incompatible with -r, -replay-log and -persona. Use -t and -vu-session")
+			flag.Usage()
+			os.Exit(1)
+		}
+	}
 
-	flag.Parse()
-	if cipherSuite != "" {
-		if ok, cipherSuiteID = checkCipherSuiteName(cipherSuite); !ok {
-			fmt.Println("Error: Unknown cipher suite:", cipherSuite)
-			fmt.Println("Valid suites:")
-			printCipherSuiteNames()
+	if targetP99 > 0 {
+		if stages != nil || sineMeanRate > 0 {
+			fmt.Println("Only one should be provided: [target-p99|stages|sine-rate]")
+			flag.Usage()
 			os.Exit(1)
 		}
+		rate := targetRate
+		if rate == 0 {
+			rate = 10
+		}
+		configuration.target = newLatencyTarget(targetP99, rate)
+		configuration.pacer = newPacer(configuration.target.currentRate)
 	}
 
-	signalChan := make(chan os.Signal, 2)
-	signal.Notify(signalChan, os.Interrupt)
+	if stages != nil {
+		configuration.stages = stages
+		configuration.pacer = newPacer(stagesRate(stages))
+	}
 
-	respChan := make(chan *resp, 2*clients)
-	errChan := make(chan error, 2*clients)
-	dumpChan := make(chan string, 2*clients)
-	exitChan := make(chan bool, 2*clients)
+	if sineMeanRate > 0 {
+		configuration.pacer = newPacer(sineRate(sineMeanRate, sineAmplitude, sinePeriod))
+	}
 
-	configuration := NewConfiguration()
+	if period != -1 {
+		configuration.period = period
+	}
 
-	goMaxProcs := os.Getenv("GOMAXPROCS")
+	if requests != -1 {
+		configuration.requests = requests
+	}
 
-	if goMaxProcs == "" {
-		runtime.GOMAXPROCS(runtime.NumCPU())
+	if totalRequests > 0 {
+		configuration.queue = &workQueue{total: totalRequests}
 	}
 
-	fmt.Printf("Dispatching %d clients\n", clients)
+	if urlsFilePath != "" {
+		fileLines, err := readLines(urlsFilePath)
 
-	runningGoroutines = clients
-	for i := 0; i < clients; i++ {
-		result := &Result{}
-		results[i] = result
-		go client(configuration, result, errChan, respChan, dumpChan, exitChan)
+		if err != nil {
+			log.Fatalf("Error in ioutil.ReadFile for file: %s Error: %s", urlsFilePath, err)
+		}
+
+		for i, line := range fileLines {
+			fileLines[i] = expandEnv(line)
+		}
+
+		configuration.urls = fileLines
 	}
-	fmt.Println("Waiting for results...")
-	for runningGoroutines > 0 {
-		select {
-		case err := <-errChan:
+
+	if mixSpec != "" {
+		if urlsFilePath != "" || replayLog != "" {
+			fmt.Println("-mix is incompatible with -f and -replay")
+			flag.Usage()
+			os.Exit(1)
+		}
+		mix, err := parseMix(mixSpec, targetURL)
+		if err != nil {
+			fmt.Println("Error in -mix:", err)
+			flag.Usage()
+			os.Exit(1)
+		}
+		configuration.mix = mix
+	}
+
+	if validatorCmd != "" {
+		if validatorSample <= 0 || validatorSample > 100 || validatorRunners < 1 {
+			fmt.Println("-validator-sample must be between 0 and 100 and -validator-runners at least 1")
+			flag.Usage()
+			os.Exit(1)
+		}
+		configuration.validator = newValidator(validatorCmd, validatorRunners)
+	}
+
+	for _, value := range personaFlags {
+		p, err := parsePersona(value)
+		if err != nil {
+			fmt.Println("Error in -persona:", err)
+			flag.Usage()
+			os.Exit(1)
+		}
+		configuration.personas = append(configuration.personas, p)
+	}
+
+	if replayLog != "" {
+		replay, err := loadReplayLog(replayLog)
+		if err != nil {
+			log.Fatalf("Error loading replay log %s: %s", replayLog, err)
+		}
+		configuration.replay = replay
+	}
+
+	dialFunction := MyDialer(&net.Dialer{FallbackDelay: fallbackDelay}, ipFamily)
+
+	certificateExpectedName := parseHostname(targetURL)
+	if resolve != "" {
+		certificateExpectedName = resolve
+	}
+	if sniName != "" {
+		certificateExpectedName = sniName
+	}
+
+	cert, err := loadClientCertificate()
+	if err != nil {
+		log.Fatal(err)
+	}
+	clientCertificate.Store(&cert)
+	if mtlsCertFile != "" || pkcs12File != "" {
+		go reloadClientCertificates(certReloadInterval)
+	}
+
+	var echConfigList []byte
+	if echConfig != "" {
+		echConfigList, err = loadECHConfig(echConfig, targetURL)
+		if err != nil {
+			log.Fatalf("Error loading ECH config: %s", err)
+		}
+	}
+
+	var sessionCache tls.ClientSessionCache
+	// HTTP/3 resumes sessions to send 0-RTT
+	if tlsResume || http3Mode {
+		sessionCache = tls.NewLRUClientSessionCache(clients)
+	}
+
+	poolSize, maxConnections := clients, 0
+	if n, err := strconv.Atoi(connections); err == nil {
+		poolSize, maxConnections = n, n
+	}
+
+	var protocols *http.Protocols
+	if h2cMode {
+		// HTTP/2 for https:// URLs too, as a server that speaks h2c
+		// speaks h2
+		protocols = new(http.Protocols)
+		protocols.SetHTTP2(true)
+		protocols.SetUnencryptedHTTP2(true)
+	}
+
+	var keyLog io.Writer
+	if keyLogFile != "" {
+		// Left open for the connections of the whole run
+		if keyLog, err = os.OpenFile(keyLogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600); err != nil {
+			log.Fatalf("Error opening key log %s: %s", keyLogFile, err)
+		}
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:                     certificateExpectedName,
+		InsecureSkipVerify:             insecureSkipVerify,
+		GetClientCertificate:           getClientCertificate,
+		CipherSuites:                   cipherSuites,
+		CurvePreferences:               curvePreferences,
+		ClientSessionCache:             sessionCache,
+		EncryptedClientHelloConfigList: echConfigList,
+		VerifyConnection:               verifyConnection,
+		KeyLogWriter:                   keyLog,
+	}
+	forceHTTP2 := false
+	if alpnList != "" {
+		for _, protocol := range strings.Split(alpnList, ",") {
+			protocol = strings.TrimSpace(protocol)
+			tlsConfig.NextProtos = append(tlsConfig.NextProtos, protocol)
+			// The transport only speaks HTTP/2 over TLS when asked to
+			forceHTTP2 = forceHTTP2 || protocol == "h2"
+		}
+	}
+
+	configuration.myClient = &http.Client{
+		Transport: &http.Transport{
+			Protocols:           protocols,
+			DialContext:         dialFunction,
+			MaxIdleConnsPerHost: poolSize,
+			MaxIdleConns:        poolSize,
+			MaxConnsPerHost:     maxConnections,
+			DisableKeepAlives:   !configuration.keepAlive,
+			// Accept-Encoding is sent and gzip responses decompressed by
+			// newRequest and send, so that both sizes can be counted
+			DisableCompression: true,
+			TLSClientConfig:    tlsConfig,
+			ForceAttemptHTTP2:  forceHTTP2,
+		},
+	}
+	if http3Mode {
+		configuration.myClient.Transport = newHTTP3Transport(tlsConfig)
+	}
+
+	if configuration.mix != nil {
+		configuration.urls = configuration.mix.urls()
+	} else if targetURL != "" {
+		configuration.urls = append(configuration.urls, targetURL)
+	}
+
+	if basicAuth != "" {
+		credentials := secret(basicAuth)
+		if !strings.Contains(credentials, ":") {
+			fmt.Println("-basic must be given as user:password")
+			flag.Usage()
+			os.Exit(1)
+		}
+		configuration.authHeader = "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))
+	}
+
+	if clientCertsPath != "" {
+		if configuration.clientCerts, err = loadClientCertificates(clientCertsPath); err != nil {
+			log.Fatalf("Error loading client certificates: %s", err)
+		}
+	}
+
+	if tokensFile != "" {
+		lines, err := readLines(tokensFile)
+		if err != nil {
+			log.Fatalf("Error in ioutil.ReadFile for file: %s Error: %s", tokensFile, err)
+		}
+		for _, line := range lines {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				configuration.tokens = append(configuration.tokens, expandEnv(line))
+			}
+		}
+		if len(configuration.tokens) == 0 {
+			log.Fatalf("No tokens in %s", tokensFile)
+		}
+	}
+
+	if userAgentsFile != "" {
+		lines, err := readLines(userAgentsFile)
+		if err != nil {
+			log.Fatalf("Error in ioutil.ReadFile for file: %s Error: %s", userAgentsFile, err)
+		}
+		for _, line := range lines {
+			if line = strings.TrimSpace(line); line != "" {
+				configuration.userAgents = append(configuration.userAgents, line)
+			}
+		}
+		if len(configuration.userAgents) == 0 {
+			log.Fatalf("No user agents in %s", userAgentsFile)
+		}
+	}
+
+	for _, cookie := range cookies {
+		name, value, ok := strings.Cut(cookie, "=")
+		if !ok || strings.TrimSpace(name) == "" {
+			fmt.Println("Cookies must be given as name=value:", cookie)
+			flag.Usage()
+			os.Exit(1)
+		}
+		configuration.cookies = append(configuration.cookies, &http.Cookie{Name: strings.TrimSpace(name), Value: strings.TrimSpace(value)})
+	}
+
+	if dataCSV != "" {
+		columns, rows, err := loadDataCSV(dataCSV)
+		if err != nil {
+			log.Fatalf("Error loading %s: %s", dataCSV, err)
+		}
+		dataColumns = columns
+		configuration.dataRows = rows
+	}
+
+	for _, u := range configuration.urls {
+		t, err := parseTemplate(u)
+		if err != nil {
+			log.Fatalf("Error in URL %s: %s", u, err)
+		}
+		if t != nil {
+			if configuration.urlTemplates == nil {
+				configuration.urlTemplates = make(map[string]*template)
+			}
+			configuration.urlTemplates[u] = t
+		}
+	}
+
+	if postDataFilePath != "" {
+		info, err := os.Stat(postDataFilePath)
+		if err != nil {
+			log.Fatalf("Error in ioutil.ReadFile for file path: %s Error: %s", postDataFilePath, err)
+		}
+		if info.Size() > streamThreshold {
+			// Too big to hold in memory, and so to fill in placeholders
+			if configuration.streamed, err = newFileBody(postDataFilePath); err != nil {
+				log.Fatalf("Error opening file path: %s Error: %s", postDataFilePath, err)
+			}
+		} else {
+			data, err := ioutil.ReadFile(postDataFilePath)
+
+			if err != nil {
+				log.Fatalf("Error in ioutil.ReadFile for file path: %s Error: %s", postDataFilePath, err)
+			}
+
+			configuration.postData = data
+		}
+	}
+
+	if requestBody != "" {
+		configuration.postData = []byte(requestBody)
+	}
+
+	if len(urlencodedFields) > 0 {
+		// Keep the fields in the order they were given, which url.Values
+		// would sort
+		encoded := make([]string, 0, len(urlencodedFields))
+		for _, field := range urlencodedFields {
+			name, value, ok := strings.Cut(field, "=")
+			if !ok || name == "" {
+				fmt.Println("-data-urlencode fields must be given as name=value:", field)
+				flag.Usage()
+				os.Exit(1)
+			}
+			encoded = append(encoded, url.QueryEscape(name)+"="+url.QueryEscape(value))
+		}
+		configuration.postData = []byte(strings.Join(encoded, "&"))
+		configuration.postDataType = "application/x-www-form-urlencoded"
+	}
+
+	if len(configuration.postData) > 0 {
+		p, err := newPayload(configuration.postData)
+		if err != nil {
+			log.Fatalf("Error in the request body: %s", err)
+		}
+		configuration.payloads = []*payload{p}
+	}
+
+	if postDataDir != "" {
+		payloads, err := loadPayloads(postDataDir)
+		if err != nil {
+			log.Fatalf("Error loading request bodies from %s: %s", postDataDir, err)
+		}
+		configuration.payloads = payloads
+	}
+
+	if bodySize != "" {
+		p, err := randomPayload(bodySize)
+		if err != nil {
+			fmt.Println("Error in -body-size:", err)
+			flag.Usage()
+			os.Exit(1)
+		}
+		configuration.payloads = []*payload{p}
+	}
+
+	if len(formFields) > 0 {
+		form, err := newMultipartBody(formFields)
+		if err != nil {
+			log.Fatalf("Error building the form: %s", err)
+		}
+		configuration.streamed = form
+	}
+
+	// The body is sent with whatever method is asked for, and only makes the
+	// method default to POST
+	if requestMethod != "" {
+		if !validMethod(requestMethod) {
+			fmt.Println("Invalid HTTP method:", requestMethod)
+			flag.Usage()
+			os.Exit(1)
+		}
+		configuration.method = requestMethod
+	} else if bodies > 0 {
+		configuration.method = "POST"
+	}
+
+	configuration.myClient.Timeout = time.Duration(readTimeout) * time.Millisecond
+
+	return configuration
+}
+
+// validMethod reports whether method is a syntactically valid HTTP method, a
+// token as defined by RFC 9110
+func validMethod(method string) bool {
+	if method == "" {
+		return false
+	}
+	for _, c := range method {
+		if c > unicode.MaxASCII || c <= ' ' || strings.ContainsRune("\"(),/:;<=>?@[\\]{}", c) {
+			return false
+		}
+	}
+	return true
+}
+
+func parseHostname(address string) string {
+	u, err := url.Parse(address)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Without the port, which has no place in the server name
+	return u.Hostname()
+}
+
+// MyDialer returns a dial function that counts the bytes read and written on
+// each connection. family restricts connections to "4" (IPv4) or "6" (IPv6);
+// otherwise dialer's Happy Eyeballs settings choose between them.
+func MyDialer(dialer *net.Dialer, family string) func(ctx context.Context, network string, address string) (net.Conn, error) {
+	return func(ctx context.Context, network string, address string) (net.Conn, error) {
+		if family != "" {
+			network = "tcp" + family
+		}
+		conn, err := dialer.DialContext(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+
+		if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok && addr.IP.To4() == nil {
+			atomic.AddInt64(&ipv6Connections, 1)
+		} else {
+			atomic.AddInt64(&ipv4Connections, 1)
+		}
+
+		myConn := &MyConn{Conn: conn}
+		if tcpInfoSamples != nil {
+			tcpInfoSamples.register(myConn)
+		}
+
+		return myConn, nil
+	}
+}
+
+// body returns the request body to send as the nth request of a client, with
+// any placeholders in it filled in afresh from row
+func (c *Configuration) body(n int, row []string) []byte {
+	if len(c.payloads) == 0 {
+		return nil
+	}
+	return c.payloads[n%len(c.payloads)].body(row)
+}
+
+// row returns the nth row of the -data-csv file, wrapping around
+func (c *Configuration) row(n int) []string {
+	if len(c.dataRows) == 0 {
+		return nil
+	}
+	return c.dataRows[n%len(c.dataRows)]
+}
+
+// requestURL returns the URL to request for url, with any placeholders in it
+// filled in afresh from row and the -cache-bust parameter added
+func (c *Configuration) requestURL(url string, row []string) string {
+	if t := c.urlTemplates[url]; t != nil {
+		url = t.expand(row)
+	}
+	if cacheBust != "" {
+		separator := "?"
+		if strings.Contains(url, "?") {
+			separator = "&"
+		}
+		url += separator + cacheBust + "=" + strconv.FormatUint(uint64(rand.Int63()), 36)
+	}
+	return url
+}
+
+// newRequest builds the request that a client sends to url, carrying postData
+// or the streamed body
+func newRequest(configuration *Configuration, url string, postData []byte) (*http.Request, error) {
+	var body io.Reader
+	if len(postData) > 0 {
+		body = bytes.NewReader(postData)
+	}
+	if configuration.streamed != nil {
+		body = configuration.streamed.reader()
+		if compressBody {
+			body = gzipStream(body)
+		}
+	}
+	req, err := http.NewRequest(configuration.method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if configuration.postDataType != "" {
+		req.Header.Set("Content-Type", configuration.postDataType)
+	}
+	if configuration.streamed != nil {
+		if !compressBody {
+			req.ContentLength = configuration.streamed.length
+		}
+		if configuration.streamed.contentType != "" {
+			req.Header.Set("Content-Type", configuration.streamed.contentType)
+		}
+	}
+	// req.Close is true when keep alives are off. But also set in Transport which seems to do the work
+	req.Close = !configuration.keepAlive
+	for name, values := range configuration.headers {
+		if strings.EqualFold(name, "Host") {
+			req.Host = values[0]
+			continue
+		}
+		req.Header[name] = append([]string(nil), values...)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if userAgent != "" {
+		req.Header.Set("User-Agent", userAgent)
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if acceptGzip && req.Header.Get("Accept-Encoding") == "" {
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
+	if !cookieJar {
+		// With a cookie jar the cookies are in the jar
+		for _, cookie := range configuration.cookies {
+			req.AddCookie(cookie)
+		}
+	}
+	if compressBody && body != nil {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if len(configuration.authHeader) > 0 {
+		req.Header.Set("Authorization", configuration.authHeader)
+	}
+	if hostHeader != "" {
+		req.Host = hostHeader
+	}
+	if len(configuration.trailers) > 0 {
+		// Trailers can only follow a chunked body
+		if req.Body == nil {
+			req.Body = ioutil.NopCloser(strings.NewReader(""))
+		}
+		req.ContentLength = -1
+		req.Trailer = configuration.trailers.Clone()
+	}
+	return req, nil
+}
+
+// report hands a message to the main goroutine for printing without waiting,
+// dropping it if the main goroutine has fallen behind
+func report[T any](messages chan T, message T) {
+	select {
+	case messages <- message:
+	default:
+		atomic.AddInt64(&droppedMessages, 1)
+	}
+}
+
+// worker holds the state of one client between requests
+type worker struct {
+	ctx           context.Context
+	configuration *Configuration
+	httpClient    *http.Client
+	token         string
+	result        *Result
+	errChan       chan error
+	dumpChan      chan string
+	batch         *respBatch
+	cycle         int
+	sent          int
+	rowCursor     int
+	method        string
+	matrixValue   string
+	operation     string
+	due           time.Time
+	breaker       *breaker
+	traceID       string
+	endpoint      string
+}
+
+func client(ctx context.Context, configuration *Configuration, result *Result, errChan chan error, batchChan chan []resp, dumpChan chan string, exitChan chan bool) {
+
+	w := &worker{
+		ctx:           ctx,
+		configuration: configuration,
+		result:        result,
+		errChan:       errChan,
+		dumpChan:      dumpChan,
+		batch:         newRespBatch(batchChan),
+		httpClient:    configuration.myClient,
+	}
+	if n := len(configuration.clientCerts); n > 0 {
+		w.httpClient = configuration.withClientCertificate(configuration.clientCerts[(atomic.AddUint64(&clientCertCursor, 1)-1)%uint64(n)])
+	}
+	if connections == "client" {
+		if len(configuration.clientCerts) == 0 {
+			w.httpClient = configuration.withKeepAlive(configuration.keepAlive).myClient
+		}
+		w.httpClient.Transport.(*http.Transport).MaxConnsPerHost = 1
+	}
+	if cookieJar {
+		w.httpClient = newCookieClient(configuration, w.httpClient)
+	}
+	if n := len(configuration.tokens); n > 0 {
+		w.token = configuration.tokens[(atomic.AddUint64(&tokenCursor, 1)-1)%uint64(n)]
+	}
+	if len(configuration.payloads) > 1 {
+		w.sent = rand.Intn(len(configuration.payloads))
+	}
+	if len(configuration.dataRows) > 1 {
+		w.rowCursor = rand.Intn(len(configuration.dataRows))
+	}
+	if breakerThreshold > 0 {
+		w.breaker = &breaker{threshold: breakerThreshold, cooldown: breakerCooldown, result: result}
+	}
+	defer func() {
+		w.batch.flush()
+		exitChan <- true
+	}()
+
+	// A random delay before the first request keeps clients from sending in
+	// lockstep
+	if stagger > 0 && !sleep(ctx, time.Duration(rand.Int63n(int64(stagger)))) {
+		return
+	}
+
+	if configuration.replay != nil {
+		for {
+			entry, ok := configuration.replay.wait(ctx)
+			if !ok {
+				return
+			}
+			w.method = entry.method
+			if !w.do(entry.url) {
+				return
+			}
+		}
+	}
+
+	var operations []*Configuration
+	if configuration.mix != nil {
+		operations = configuration.mix.configurations(configuration)
+	}
+
+	pacer := configuration.pacer
+	if clientRate > 0 {
+		pacer = newPacer(func(time.Duration) float64 { return clientRate })
+	}
+
+	// next returns the URL to request next, or false once the client has
+	// sent its share. Without -n each client goes through all the URLs in
+	// turn until it has sent -r requests.
+	next := func() (string, bool) {
+		return configuration.queue.take(configuration.urls)
+	}
+	if configuration.queue == nil {
+		sent := 0
+		next = func() (string, bool) {
+			if sent%len(configuration.urls) == 0 && result.requests >= configuration.requests {
+				return "", false
+			}
+			sent++
+			return configuration.urls[(sent-1)%len(configuration.urls)], true
+		}
+	}
+
+	for {
+		tmpUrl, ok := next()
+		if !ok {
+			return
+		}
+		if operations != nil {
+			tmpUrl = w.pickOperation(configuration.mix, operations)
+		}
+		if configuration.dutyCycle != nil {
+			w.cycle = configuration.dutyCycle.wait(ctx)
+		}
+		if configuration.burst != nil {
+			w.cycle = configuration.burst.wait(ctx)
+		}
+		if pacer != nil {
+			w.due = pacer.wait(ctx)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if malformedPercent > 0 && rand.Float64()*100 < malformedPercent {
+			w.sendMalformed(tmpUrl)
+			continue
+		}
+		if !w.do(tmpUrl) {
+			return
+		}
+		if configuration.thinkTime > 0 && !sleep(ctx, configuration.thinkTime) {
+			return
+		}
+	}
+}
+
+// do sends one logical request to url, retrying it as configured. It returns
+// false if the run ended while the request was in flight.
+func (w *worker) do(url string) bool {
+	if otlpTraces {
+		w.traceID = newTraceID()
+	}
+	// Retries resend the same URL, headers and body
+	url, body, headers := w.prepare(url)
+	for attempt := 0; ; attempt++ {
+		if w.breaker != nil && !w.breaker.wait(w.ctx) {
+			return false
+		}
+		req, err := w.newRequest(url, body, headers)
+		if err != nil {
+			report(w.errChan, err)
+			w.result.requests++
+			w.result.networkFailed++
+			return true
+		}
+
+		statusCode, ok := w.send(req)
+		if w.breaker != nil {
+			w.breaker.record(statusCode)
+		}
+		if !ok {
+			return false
+		}
+		if attempt >= w.configuration.retries || (statusCode != 0 && statusCode < 500 && !(retryAfter && statusCode == http.StatusTooManyRequests)) {
+			return true
+		}
+		w.result.retries++
+	}
+}
+
+// pickOperation switches the client to a -mix operation picked by weight,
+// with operations the configurations of the operations, and returns its URL
+func (w *worker) pickOperation(mix *trafficMix, operations []*Configuration) string {
+	i := mix.pick()
+	op := mix.operations[i]
+	w.configuration, w.operation = operations[i], op.name
+	return op.url
+}
+
+// prepare returns the URL, body and headers of the client's next request to
+// url, with any placeholders in them filled in
+func (w *worker) prepare(url string) (string, []byte, http.Header) {
+	headers := w.requestHeaders()
+	row := w.configuration.row(w.rowCursor)
+	w.rowCursor++
+	w.endpoint = url
+	url = w.configuration.requestURL(url, row)
+	body := w.configuration.body(w.sent, row)
+	w.sent++
+	return url, body, headers
+}
+
+// requestHeaders picks the headers of the client's next request: its token,
+// and the user agent, idempotency key and -header-matrix value of the request
+func (w *worker) requestHeaders() http.Header {
+	headers := make(http.Header)
+	if w.token != "" {
+		headers.Set("Authorization", w.token)
+	}
+	if n := len(w.configuration.userAgents); n > 0 {
+		headers.Set("User-Agent", w.configuration.userAgents[(atomic.AddUint64(&userAgentCursor, 1)-1)%uint64(n)])
+	}
+	if w.configuration.idempotencyKeys {
+		headers.Set("Idempotency-Key", newUUID())
+	}
+	if n := len(w.configuration.matrixValues); n > 0 {
+		w.matrixValue = w.configuration.matrixValues[(atomic.AddUint64(&matrixCursor, 1)-1)%uint64(n)]
+		headers.Set(w.configuration.matrixHeader, w.matrixValue)
+	}
+	return headers
+}
+
+// newRequest builds the client's request to url with body and headers
+func (w *worker) newRequest(url string, body []byte, headers http.Header) (*http.Request, error) {
+	req, err := newRequest(w.configuration, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if w.method != "" {
+		req.Method = w.method
+	}
+	for name, values := range headers {
+		req.Header[name] = values
+	}
+	return req, nil
+}
+
+// send sends req and records the outcome. It returns the status code, or 0 if
+// the request failed, and false if the run ended while it was in flight.
+func (w *worker) send(req *http.Request) (int, bool) {
+	var size int
+	var statusCode int
+	var delay time.Duration
+
+	var spanID string
+	if w.traceID != "" {
+		spanID = newSpanID()
+		req.Header.Set("traceparent", "00-"+w.traceID+"-"+spanID+"-01")
+	}
+
+	requestStartTime := time.Now()
+	ctx := w.ctx
+	var timing *requestTiming
+	// Only traced for the features that read the trace, as it costs a
+	// little on every request
+	if slowest > 0 || (tlsTiming && req.URL.Scheme == "https" && !http3Mode) {
+		ctx, timing = traceTiming(ctx, requestStartTime)
+	}
+	res, err := w.httpClient.Do(req.WithContext(ctx))
+	requestReplyTime := time.Now()
+	elapsed := latencyMicroseconds(requestReplyTime.Sub(requestStartTime))
+	tooSlow := failOver > 0 && requestReplyTime.Sub(requestStartTime) > failOver
+	corrected := int64(-1)
+	if !w.due.IsZero() {
+		corrected = latencyMicroseconds(requestReplyTime.Sub(w.due))
+	}
+
+	if err != nil {
+		if w.ctx.Err() != nil {
+			// Cut short by the end of the run rather than a real failure
+			return 0, false
+		}
+		countHandshakeError(err)
+		report(w.errChan, err)
+		w.batch.add(resp{
+			sent:     requestStartTime,
+			received: requestReplyTime,
+			url:      req.URL.String(),
+			status:   0,
+			latency:  elapsed,
+			size:     0,
+			cycle:    w.cycle,
+			method:   req.Method,
+			persona:  w.configuration.persona,
+			err:      err.Error(),
+
+			errorKind:   classifyError(err),
+			matrixValue: w.matrixValue,
+			operation:   w.operation,
+			endpoint:    w.endpoint,
+			corrected:   corrected,
+			traceID:     w.traceID,
+			spanID:      spanID,
+			timing:      timing.snapshot(),
+		})
+		statusCode = 0
+	} else {
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		// latency is up to the response headers, which is as good as the
+		// first byte, and complete up to the end of the body
+		complete := latencyMicroseconds(time.Since(requestStartTime))
+		// size is what came over the wire, before any decompression
+		size = len(body) + 2
+		if acceptGzip && strings.EqualFold(res.Header.Get("Content-Encoding"), "gzip") && len(body) > 0 {
+			w.result.gzipResponses++
+			w.result.compressedBytes += int64(len(body))
+			if decompressed, err := gunzipBytes(body); err != nil {
+				report(w.errChan, fmt.Errorf("decompressing response from %s: %w", req.URL, err))
+			} else {
+				body = decompressed
+			}
+			w.result.decompressedBytes += int64(len(body))
+		}
+		if dumpResponse && atomic.AddInt64(&dumpsRemaining, -1) >= 0 {
+			report(w.dumpChan, string(body))
+		}
+		for key, value := range res.Header {
+			for _, s := range value {
+				size += len(s) + 2
+			}
+			size += len(key) + 2
+		}
+		var trailers []string
+		for key, value := range res.Trailer {
+			for _, s := range value {
+				trailers = append(trailers, key+": "+s)
+				size += len(key) + len(s) + 4
+			}
+		}
+		sort.Strings(trailers)
+		if w.configuration.validator != nil && rand.Float64()*100 < validatorSample {
+			w.configuration.validator.submit(req.URL.String(), res.StatusCode, body, trailers)
+		}
+		var bodyHash uint64
+		if hashBodies && (hashExcluded == nil || !hashExcluded.MatchString(w.endpoint)) {
+			hash := fnv.New64a()
+			hash.Write(body)
+			bodyHash = hash.Sum64()
+		}
+		w.batch.add(resp{
+			sent:     requestStartTime,
+			received: requestReplyTime,
+			url:      req.URL.String(),
+			status:   res.StatusCode,
+			latency:  elapsed,
+			complete: complete,
+			size:     size,
+			cycle:    w.cycle,
+			tooSlow:  tooSlow,
+			trailers: trailers,
+			bodyHash: bodyHash,
+			method:   req.Method,
+			persona:  w.configuration.persona,
+
+			matrixValue: w.matrixValue,
+			operation:   w.operation,
+			endpoint:    w.endpoint,
+			corrected:   corrected,
+			traceID:     w.traceID,
+			spanID:      spanID,
+			timing:      timing.snapshot(),
+		})
+		statusCode = res.StatusCode
+		if replayHeader != "" && strings.EqualFold(res.Header.Get(replayHeader), "true") {
+			w.result.replayed++
+		}
+		if retryAfter && (statusCode == http.StatusTooManyRequests || statusCode == http.StatusServiceUnavailable) {
+			delay = parseRetryAfter(res.Header.Get("Retry-After"), requestReplyTime)
+		}
+	}
+	w.result.requests++
+
+	if err != nil {
+		w.result.networkFailed++
+		return statusCode, true
+	}
+
+	if statusCode >= 200 && statusCode < 300 {
+		if tooSlow {
+			w.result.tooSlow++
+		} else {
+			w.result.success++
+		}
+	} else if retryAfter && (statusCode == http.StatusTooManyRequests || statusCode == http.StatusServiceUnavailable) {
+		w.result.rateLimited++
+		if delay > 0 {
+			paused := time.Now()
+			defer func() { w.result.rateLimitDelay += time.Since(paused) }()
+			return statusCode, sleep(w.ctx, delay)
+		}
+	} else {
+		w.result.badFailed++
+	}
+	return statusCode, true
+}
+
+// parseRetryAfter returns how long a Retry-After header value asks the client
+// to wait from now, capped at -retry-after-max
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	var delay time.Duration
+	if seconds, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+		delay = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(value); err == nil {
+		delay = date.Sub(now)
+	}
+	if delay < 0 {
+		return 0
+	}
+	if delay > retryAfterMax {
+		return retryAfterMax
+	}
+	return delay
+}
+
+func main() {
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		for _, command := range commands {
+			if args[0] == command.name {
+				command.run(args[1:])
+				return
+			}
+		}
+		if args[0] != "help" {
+			fmt.Println("Unknown command:", args[0])
+		}
+		flag.Usage()
+		os.Exit(1)
+	}
+	runCommand(args)
+}
+
+// setup parses and checks the flags in args and builds the configuration from
+// them. Without -r or -t, workloads run until they are stopped if untilStopped
+// is set.
+func setup(args []string, untilStopped bool) (*Configuration, chan os.Signal) {
+
+	flag.CommandLine.Parse(args)
+	if untilStopped && requests == -1 && period == -1 {
+		period = 0
+	}
+	applyEnvironment()
+	switch outputFormat {
+	case "text":
+	case "json", "csv", "wrk":
+		// Keep stdout for the report alone
+		reportOutput = os.Stdout
+		os.Stdout = os.Stderr
+	default:
+		fmt.Println("-o must be text, json, csv or wrk")
+		flag.Usage()
+		os.Exit(1)
+	}
+	if quiet {
+		if outputFormat != "text" || untilStopped {
+			fmt.Println("-q can't be used with -o or in the console")
+			flag.Usage()
+			os.Exit(1)
+		}
+		// Everything but the summary line goes nowhere
+		reportOutput = os.Stdout
+		os.Stdout, _ = os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	}
+	colorOutput = isTerminal(os.Stdout) && os.Getenv("NO_COLOR") == ""
+	showProgress = showProgress && !untilStopped && isTerminal(os.Stdout)
+	if cipherSuite != "" {
+		var err error
+		if cipherSuites, err = parseCipherSuites(cipherSuite); err != nil {
+			fmt.Println("Error:", err)
+			fmt.Println("Valid suites:")
+			printCipherSuiteNames()
+			fmt.Println("Presets:")
+			printCipherPresets()
+			os.Exit(1)
+		}
+	}
+	if curveList != "" {
+		var err error
+		if curvePreferences, err = parseCurves(curveList); err != nil {
+			fmt.Println("Error:", err)
+			fmt.Println("Valid curves:")
+			printCurveNames()
+			os.Exit(1)
+		}
+	}
+
+	signalChan := make(chan os.Signal, 2)
+	signal.Notify(signalChan, os.Interrupt)
+
+	configuration := NewConfiguration()
+
+	if printConfiguration || dryRun {
+		printConfig(configuration)
+	}
+	if dryRun {
+		printRequests(configuration, 3)
+		os.Exit(0)
+	}
+
+	goMaxProcs := os.Getenv("GOMAXPROCS")
+
+	if goMaxProcs == "" {
+		runtime.GOMAXPROCS(runtime.NumCPU())
+	}
+
+	if controlAddress != "" {
+		if err := listenControl(controlAddress); err != nil {
+			log.Fatalf("Error listening on %s: %s", controlAddress, err)
+		}
+	}
+
+	if targetP99 > 0 {
+		fmt.Printf("Adjusting the load to keep p99 within %v\n", targetP99)
+	} else if targetRate > 0 {
+		fmt.Printf("Constant load of %.0f hits/sec\n", targetRate)
+	}
+	if sineMeanRate > 0 {
+		fmt.Printf("Sinusoidal load of %.0f±%.0f hits/sec over %v\n", sineMeanRate, sineMeanRate*sineAmplitude, sinePeriod)
+	}
+
+	return configuration, signalChan
+}
+
+// runCommand runs a benchmark and prints its results
+func runCommand(args []string) {
+	configuration, signalChan := setup(args, false)
+
+	if compareKeepAlive {
+		compareKeepAlives(configuration, signalChan)
+		os.Exit(0)
+	}
+
+	stats := run(configuration, signalChan, nil)
+	collector := stats.collector
+	printResults(stats.results, stats.elapsed)
+	if len(collector.networkErrors) > 0 {
+		printNetworkErrors(collector.networkErrors)
+	}
+	printLatency(collector)
+	if collector.clamped > 0 {
+		fmt.Printf("%d latencies were longer than -histogram-max %v and were counted as %v\n\n", collector.clamped, histogramMax, histogramMax)
+	}
+	if throughputChart {
+		printThroughputChart(collector.timeline, stats.elapsed)
+	}
+	if configuration.dutyCycle != nil {
+		printCycles(collector.cycles, configuration.dutyCycle)
+	}
+	if configuration.stages != nil {
+		printStages(collector.stages, collector.stageResults, stats.elapsed)
+	}
+	if configuration.target != nil {
+		configuration.target.print()
+	}
+	if configuration.burst != nil {
+		printBursts(collector.bursts, configuration.burst)
+	}
+	if len(collector.trailers) > 0 {
+		printTrailers(collector.trailers)
+	}
+	if len(collector.malformed) > 0 {
+		printMalformed(collector.malformed)
+	}
+	if tcpInfoSamples != nil {
+		tcpInfoSamples.print()
+	}
+	if collector.drift != nil {
+		collector.drift.print()
+	}
+	if collector.bodyHashes != nil {
+		collector.bodyHashes.print()
+	}
+	if collector.matrix != nil {
+		collector.matrix.print(stats.elapsed)
+	}
+	if collector.personas != nil {
+		collector.personas.print(stats.elapsed)
+	}
+	if collector.operations != nil {
+		collector.operations.print(stats.elapsed)
+	}
+	if collector.urls != nil {
+		collector.urls.print(stats.elapsed)
+	}
+	if printSizes {
+		collector.sizes.print()
+	}
+	if collector.slowest != nil {
+		collector.slowest.print()
+	}
+	if configuration.validator != nil {
+		configuration.validator.wait()
+		configuration.validator.print()
+	}
+	if spectrumFile != "" {
+		if err := saveSpectrum(spectrumFile, collector.latencies); err != nil {
+			log.Fatalf("Error writing spectrum to %s: %s", spectrumFile, err)
+		}
+	}
+	if reportJSON != "" {
+		if err := writeReport(reportJSON, configuration, stats); err != nil {
+			log.Fatalf("Error writing report to %s: %s", reportJSON, err)
+		}
+	}
+	if timelineFile != "" {
+		if err := collector.latencyTimeline.save(timelineFile); err != nil {
+			log.Fatalf("Error writing timeline to %s: %s", timelineFile, err)
+		}
+	}
+	if reportHTML != "" {
+		if err := writeHTMLReport(reportHTML, configuration, stats); err != nil {
+			log.Fatalf("Error writing report to %s: %s", reportHTML, err)
+		}
+	}
+	if quiet {
+		fmt.Fprintln(reportOutput, summaryLine(newReport(configuration, stats)))
+	}
+	if outputFormat == "json" {
+		data, err := encodeReport(configuration, stats)
+		if err != nil {
+			log.Fatalf("Error writing report: %s", err)
+		}
+		reportOutput.Write(data)
+	}
+	if outputFormat == "wrk" {
+		writeWrk(reportOutput, configuration, stats)
+	}
+	if outputFormat == "csv" {
+		if err := writeCSV(reportOutput, newReport(configuration, stats), true); err != nil {
+			log.Fatalf("Error writing CSV: %s", err)
+		}
+	}
+	if appendCSVFile != "" {
+		if err := appendCSV(appendCSVFile, newReport(configuration, stats)); err != nil {
+			log.Fatalf("Error writing CSV to %s: %s", appendCSVFile, err)
+		}
+	}
+	failed := len(assertions) > 0 && !checkAssertions(assertions, stats)
+	if baseline != nil {
+		fmt.Printf("Compared with the baseline %s:\n", baselineFile)
+		if broken := checkBaseline(baseline, newReport(configuration, stats), failRules); len(broken) > 0 {
+			fmt.Println("Regressions against the baseline:", strings.Join(broken, ", "))
+			failed = true
+		}
+	}
+	if stats.aborted != "" {
+		fmt.Println("Run aborted:", stats.aborted)
+		os.Exit(1)
+	}
+	if failed {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// consoleCommand starts the console
+func consoleCommand(args []string) {
+	configuration, signalChan := setup(args, true)
+	console(configuration, signalChan)
+}
+
+// runStats is what one run of the clients measured
+type runStats struct {
+	results     map[int]*Result
+	collector   *collector
+	start       time.Time
+	elapsed     time.Duration
+	interrupted bool
+
+	// aborted is why the run was stopped early for too many errors
+	aborted string
+}
+
+// run dispatches the clients and collects their results until they have all
+// finished, the period is over or the run is interrupted.
+//
+// Functions sent on snapshots are called with the results so far, from the
+// goroutine that collects them.
+func run(configuration *Configuration, signalChan chan os.Signal, snapshots chan func(*collector, time.Duration)) *runStats {
+
+	startTime := time.Now()
+	var dumpCount = 5
+	var runningGoroutines int
+	results := make(map[int]*Result)
+	interrupted := false
+	aborted := ""
+
+	resetCounters()
+	if configuration.dutyCycle != nil {
+		configuration.dutyCycle.start = startTime
+	}
+	if configuration.pacer != nil {
+		configuration.pacer.reset(startTime)
+	}
+	if configuration.burst != nil {
+		configuration.burst.reset(startTime)
+	}
+	if configuration.queue != nil {
+		configuration.queue.reset()
+	}
+	if configuration.replay != nil {
+		configuration.replay.reset(startTime)
+	}
+
+	batchChan := make(chan []resp, 2*clients)
+	errChan := make(chan error, 2*clients)
+	dumpChan := make(chan string, 2*clients)
+	exitChan := make(chan bool, 2*clients)
+
+	collector := newCollector(configuration, startTime)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var summaries <-chan time.Time
+	if collector.interval != nil {
+		ticker := time.NewTicker(summaryInterval + statsInterval)
+		defer ticker.Stop()
+		summaries = ticker.C
+	}
+	var exports <-chan time.Time
+	if collector.influx != nil {
+		ticker := time.NewTicker(influxInterval)
+		defer ticker.Stop()
+		exports = ticker.C
+	}
+	var ticks <-chan time.Time
+	if collector.latencyTimeline != nil {
+		ticker := time.NewTicker(timelineInterval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+	var adjust <-chan time.Time
+	if configuration.target != nil {
+		ticker := time.NewTicker(targetInterval)
+		defer ticker.Stop()
+		adjust = ticker.C
+	}
+	var timeout <-chan time.Time
+	if configuration.period > 0 {
+		timer := time.NewTimer(time.Duration(configuration.period) * time.Second)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	fmt.Printf("Dispatching %d clients\n", clients)
+
+	clientConfigurations := make([]*Configuration, clients)
+	if len(configuration.personas) > 0 {
+		clientConfigurations = personaConfigurations(configuration, clients)
+	}
+	if vuRate > 0 {
+		slots := make([]*Result, clients)
+		for i := range slots {
+			slots[i] = &Result{}
+			results[i] = slots[i]
+		}
+		runningGoroutines = 1
+		go virtualUsers(ctx, configuration, slots, errChan, batchChan, dumpChan, exitChan)
+	} else {
+		for i := 0; i < clients; i++ {
+			if clientConfigurations[i] == nil {
+				clientConfigurations[i] = configuration
+			}
+		}
+	}
+	// stops holds a function to stop each client, so that clients can be
+	// removed while the run is going
+	var stops []context.CancelFunc
+	startClient := func(configuration *Configuration) {
+		clientCtx, stop := context.WithCancel(ctx)
+		result := &Result{}
+		results[len(results)] = result
+		stops = append(stops, stop)
+		runningGoroutines++
+		go client(clientCtx, configuration, result, errChan, batchChan, dumpChan, exitChan)
+	}
+	if vuRate == 0 {
+		for i := 0; i < clients; i++ {
+			startClient(clientConfigurations[i])
+		}
+	}
+	fmt.Println("Waiting for results...")
+	var progress *progressLine
+	var redraw <-chan time.Time
+	if showProgress {
+		if progress = newProgressLine(configuration); progress != nil {
+			ticker := time.NewTicker(progressInterval)
+			defer ticker.Stop()
+			redraw = ticker.C
+		}
+	}
+	for runningGoroutines > 0 {
+		select {
+		case err := <-errChan:
+			if progress != nil {
+				progress.clear()
+			}
 			fmt.Println("Error: ", err.Error())
-		case res := <-respChan:
-			if res.status >= 200 && res.status < 300 {
-				messageCount++
-				latencies.RecordValue(int64(res.latency))
-				if trackMaxLatency {
-					if maxLatency < 0 || res.latency > maxLatency {
-						maxLatency = res.latency
-						fmt.Println(messageCount, " latency:", res.latency, "(ms)")
-					}
+		case batch := <-batchChan:
+			collector.record(batch)
+			if aborted == "" {
+				if aborted = collector.errorLimit(); aborted != "" {
+					fmt.Println("Stopping:", aborted)
+					cancel()
 				}
 			}
 		case body := <-dumpChan:
-			if dumpCount > 0 {
-				fmt.Println(dumpCount, ": ", body)
-				dumpCount--
-			} else {
-				dumpResponse = false
-			}
+			fmt.Println(dumpCount, ": ", body)
+			dumpCount--
 		case _ = <-exitChan:
 			runningGoroutines--
+		case _ = <-timeout:
+			// Stop the clients and keep collecting until they have all
+			// handed over their last results
+			cancel()
+		case now := <-summaries:
+			if progress != nil {
+				progress.clear()
+			}
+			collector.interval.print(collector, now)
+		case now := <-redraw:
+			progress.draw(collector.responses, now.Sub(startTime))
+		case now := <-exports:
+			collector.influx.export(now)
+		case now := <-ticks:
+			collector.latencyTimeline.tick(now)
+		case _ = <-adjust:
+			configuration.target.adjust(time.Since(startTime))
+		case change := <-clientChanges:
+			if vuRate == 0 && ctx.Err() == nil {
+				count := change.n
+				if change.relative {
+					count += len(stops)
+				}
+				if count < 1 {
+					count = 1
+				}
+				for len(stops) < count {
+					startClient(clientConfigurations[len(stops)%len(clientConfigurations)])
+				}
+				for len(stops) > count {
+					stops[len(stops)-1]()
+					stops = stops[:len(stops)-1]
+				}
+				// Later runs start with as many clients as this one ended with
+				clients = count
+			}
+			change.reply <- clients
+		case snapshot := <-snapshots:
+			snapshot(collector, time.Since(startTime))
 		case _ = <-signalChan:
-
-			runningGoroutines = 0
+			interrupted = true
+			cancel()
 		}
 	}
-	printResults(results, startTime)
-	printLatency(latencies)
-	os.Exit(0)
+	if progress != nil {
+		progress.clear()
+	}
+	if droppedMessages > 0 {
+		fmt.Println("Errors and replies not printed:", droppedMessages)
+	}
+	if collector.requestLog != nil {
+		if err := collector.requestLog.close(); err != nil {
+			fmt.Println("Error writing request log:", err)
+		}
+	}
+	if collector.influx != nil {
+		collector.influx.close(time.Now())
+	}
+	if collector.latencyTimeline != nil {
+		collector.latencyTimeline.close(time.Now())
+	}
+	if collector.otlp != nil {
+		collector.otlp.close(collector, time.Now())
+	}
+	if collector.jtl != nil {
+		if err := collector.jtl.close(); err != nil {
+			fmt.Println("Error writing JTL file:", err)
+		}
+	}
+	if collector.statsd != nil {
+		collector.statsd.close()
+	}
+	return &runStats{
+		results:     results,
+		collector:   collector,
+		start:       startTime,
+		elapsed:     time.Since(startTime),
+		interrupted: interrupted,
+		aborted:     aborted,
+	}
+}
+
+// resetCounters zeroes the counters shared by all clients so that each run
+// starts afresh
+func resetCounters() {
+	atomic.StoreInt64(&readThroughput, 0)
+	atomic.StoreInt64(&writeThroughput, 0)
+	atomic.StoreInt64(&droppedMessages, 0)
+	atomic.StoreInt64(&dumpsRemaining, 5)
+	atomic.StoreInt64(&ipv4Connections, 0)
+	atomic.StoreInt64(&ipv6Connections, 0)
+	atomic.StoreInt64(&fullHandshakes, 0)
+	atomic.StoreInt64(&resumedHandshakes, 0)
+	resetQUICHandshakes()
+	resetTLSHandshakes()
+	atomic.StoreInt64(&echAccepted, 0)
+	atomic.StoreInt64(&echRejected, 0)
+	atomic.StoreInt64(&delayedArrivals, 0)
+	negotiatedCurves.Lock()
+	negotiatedCurves.counts = make(map[tls.CurveID]int64)
+	negotiatedCurves.Unlock()
+	negotiatedProtocols.Lock()
+	negotiatedProtocols.counts = make(map[string]int64)
+	negotiatedProtocols.Unlock()
 }
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// historyCommand lists the reports saved with -save or -report-json in a
+// directory, oldest first, to follow a service from run to run
+func historyCommand(args []string) {
+	flags := flag.NewFlagSet("history", flag.ExitOnError)
+	url := flags.String("url", "", "Only list the runs that requested this URL")
+	flags.Parse(args)
+	if flags.NArg() > 1 {
+		fmt.Println("Usage: gobench history [-url URL] [DIR]")
+		flags.PrintDefaults()
+		os.Exit(1)
+	}
+	dir := "."
+	if flags.NArg() == 1 {
+		dir = flags.Arg(0)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Fatalf("Error reading %s: %s", dir, err)
+	}
+	type run struct {
+		file   string
+		report *jsonReport
+	}
+	var runs []run
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		// Other JSON files, such as -log-requests logs, are skipped
+		r, err := readReport(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		matches := *url == ""
+		for _, u := range r.Config.URLs {
+			matches = matches || u == *url
+		}
+		if matches {
+			runs = append(runs, run{file: entry.Name(), report: r})
+		}
+	}
+	if len(runs) == 0 {
+		fmt.Println("No reports in", dir)
+		return
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].report.Started.Before(runs[j].report.Started) })
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetRowSeparator("-")
+	table.SetHeader([]string{"Started", "Report", "URL", "Requests", "Success/sec", "p99", "Errors"})
+	for _, run := range runs {
+		r := run.report
+		target := ""
+		if len(r.Config.URLs) > 0 {
+			target = r.Config.URLs[0]
+		}
+		if len(r.Config.URLs) > 1 {
+			target += fmt.Sprintf(" (+%d)", len(r.Config.URLs)-1)
+		}
+		p99 := "-"
+		if value, ok := r.Latency.Percentiles["99"]; ok {
+			p99 = fmt.Sprintf("%.2f %s", value, r.Latency.Unit)
+		}
+		status := ""
+		if r.Interrupted {
+			status = " (interrupted)"
+		}
+		table.Append([]string{
+			r.Started.Format("2006-01-02 15:04:05") + status,
+			run.file,
+			target,
+			fmt.Sprintf("%d", r.Results.Requests),
+			fmt.Sprintf("%.0f", r.Results.Rate),
+			p99,
+			fmt.Sprintf("%.2f%%", errorRate(r)),
+		})
+	}
+	table.Render()
+}
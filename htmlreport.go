@@ -0,0 +1,307 @@
+package main
+
+import (
+	"fmt"
+	htmltemplate "html/template"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timeSlot is what happened in one second of a run
+type timeSlot struct {
+	requests   int64
+	failed     int64
+	latencySum int64
+	maxLatency int64
+}
+
+// recordTimeline adds a response to the second of the run it was sent in,
+// growing timeline as the run goes on
+func recordTimeline(timeline []timeSlot, start time.Time, res *resp) []timeSlot {
+	second := int(res.sent.Sub(start) / time.Second)
+	if second < 0 {
+		second = 0
+	}
+	for len(timeline) <= second {
+		timeline = append(timeline, timeSlot{})
+	}
+	slot := &timeline[second]
+	slot.requests++
+	if res.status < 200 || res.status >= 300 {
+		slot.failed++
+	}
+	slot.latencySum += res.latency
+	if res.latency > slot.maxLatency {
+		slot.maxLatency = res.latency
+	}
+	return timeline
+}
+
+const (
+	chartWidth  = 800
+	chartHeight = 240
+	chartMargin = 50
+)
+
+// chartSeries is one line of a chart
+type chartSeries struct {
+	Name    string
+	Color   string
+	Points  string
+	LegendX float64
+}
+
+// chartBar is one bar of a bar chart
+type chartBar struct {
+	Label  string
+	Value  string
+	X      float64
+	Y      float64
+	Width  float64
+	Height float64
+}
+
+// chartAxis is a labelled tick on the y axis of a chart
+type chartAxis struct {
+	Label string
+	Y     float64
+}
+
+type lineChart struct {
+	Title  string
+	Unit   string
+	Series []chartSeries
+	Ticks  []chartAxis
+	Last   string
+}
+
+type htmlReport struct {
+	Report      *jsonReport
+	Flags       []htmlFlag
+	Statuses    []htmlStatus
+	Percentiles []chartBar
+	Ticks       []chartAxis
+	Throughput  lineChart
+	Latency     lineChart
+}
+
+type htmlFlag struct {
+	Name  string
+	Value string
+}
+
+type htmlStatus struct {
+	Code    string
+	Count   int64
+	Percent string
+}
+
+// chartTicks returns ticks for a y axis that goes up to max
+func chartTicks(max float64) []chartAxis {
+	var ticks []chartAxis
+	for i := 0; i <= 4; i++ {
+		value := max * float64(i) / 4
+		ticks = append(ticks, chartAxis{
+			Label: strconv.FormatFloat(value, 'f', -1, 64),
+			Y:     chartMargin + chartHeight - chartHeight*float64(i)/4,
+		})
+	}
+	return ticks
+}
+
+// chartScale rounds max up to a value that ticks divide evenly
+func chartScale(max float64) float64 {
+	if max <= 0 {
+		return 1
+	}
+	magnitude := math.Pow(10, math.Floor(math.Log10(max)))
+	for _, step := range []float64{1, 2, 4, 5, 10} {
+		if step*magnitude >= max {
+			return step * magnitude
+		}
+	}
+	return 10 * magnitude
+}
+
+// newLineChart draws each of values as a line over the seconds of the run
+func newLineChart(title string, unit string, names []string, colors []string, values [][]float64) lineChart {
+	var max float64
+	for _, series := range values {
+		for _, v := range series {
+			max = math.Max(max, v)
+		}
+	}
+	scale := chartScale(max)
+	chart := lineChart{Title: title, Unit: unit, Ticks: chartTicks(scale)}
+	for i, series := range values {
+		points := make([]string, len(series))
+		for second, v := range series {
+			x := chartMargin + chartWidth*float64(second)/math.Max(float64(len(series)-1), 1)
+			y := chartMargin + chartHeight - chartHeight*v/scale
+			points[second] = fmt.Sprintf("%.1f,%.1f", x, y)
+		}
+		chart.Series = append(chart.Series, chartSeries{
+			Name:    names[i],
+			Color:   colors[i],
+			Points:  strings.Join(points, " "),
+			LegendX: chartMargin + chartWidth - 100*float64(len(values)-i),
+		})
+	}
+	if len(values) > 0 {
+		chart.Last = strconv.Itoa(len(values[0]))
+	}
+	return chart
+}
+
+func newHTMLReport(configuration *Configuration, stats *runStats) *htmlReport {
+	r := newReport(configuration, stats)
+	h := &htmlReport{Report: r}
+
+	for name, flag := range r.Config.Flags {
+		if flag.Source != "default" {
+			h.Flags = append(h.Flags, htmlFlag{Name: name, Value: flag.Value})
+		}
+	}
+	sort.Slice(h.Flags, func(i, j int) bool { return h.Flags[i].Name < h.Flags[j].Name })
+
+	for code, count := range r.StatusCodes {
+		h.Statuses = append(h.Statuses, htmlStatus{
+			Code:    code,
+			Count:   count,
+			Percent: fmt.Sprintf("%.1f%%", float64(count)/math.Max(float64(r.Results.Requests), 1)*100),
+		})
+	}
+	if r.Results.NetworkFailed > 0 {
+		h.Statuses = append(h.Statuses, htmlStatus{
+			Code:    "network error",
+			Count:   r.Results.NetworkFailed,
+			Percent: fmt.Sprintf("%.1f%%", float64(r.Results.NetworkFailed)/math.Max(float64(r.Results.Requests), 1)*100),
+		})
+	}
+	sort.Slice(h.Statuses, func(i, j int) bool { return h.Statuses[i].Code < h.Statuses[j].Code })
+
+	var max float64
+	for _, percentile := range reportPercentiles {
+		max = math.Max(max, r.Latency.Percentiles[strconv.FormatFloat(percentile, 'f', -1, 64)])
+	}
+	scale := chartScale(max)
+	h.Ticks = chartTicks(scale)
+	barWidth := float64(chartWidth) / float64(len(reportPercentiles))
+	for i, percentile := range reportPercentiles {
+		label := strconv.FormatFloat(percentile, 'f', -1, 64)
+		value := r.Latency.Percentiles[label]
+		height := chartHeight * value / scale
+		h.Percentiles = append(h.Percentiles, chartBar{
+			Label:  label + "%",
+			Value:  fmt.Sprintf("%.2f ms", value),
+			X:      chartMargin + barWidth*float64(i) + barWidth*0.15,
+			Y:      chartMargin + chartHeight - height,
+			Width:  barWidth * 0.7,
+			Height: height,
+		})
+	}
+
+	timeline := stats.collector.timeline
+	success := make([]float64, len(timeline))
+	failed := make([]float64, len(timeline))
+	mean := make([]float64, len(timeline))
+	maxLatency := make([]float64, len(timeline))
+	for i, slot := range timeline {
+		success[i] = float64(slot.requests - slot.failed)
+		failed[i] = float64(slot.failed)
+		if slot.requests > 0 {
+			mean[i] = latencyMilliseconds(slot.latencySum) / float64(slot.requests)
+		}
+		maxLatency[i] = latencyMilliseconds(slot.maxLatency)
+	}
+	h.Throughput = newLineChart("Throughput", "hits/sec", []string{"Successful", "Failed"}, []string{"#2b7bb9", "#d9534f"}, [][]float64{success, failed})
+	h.Latency = newLineChart("Latency", "ms", []string{"Mean", "Max"}, []string{"#2b7bb9", "#f0ad4e"}, [][]float64{mean, maxLatency})
+	return h
+}
+
+// writeHTMLReport writes the report of a run as a single HTML file, with its
+// charts drawn as inline SVG so that it needs nothing else to be viewed
+func writeHTMLReport(fileName string, configuration *Configuration, stats *runStats) error {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	if err := htmlTemplate.Execute(file, newHTMLReport(configuration, stats)); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+var htmlTemplate = htmltemplate.Must(htmltemplate.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>gobench report {{.Report.Started.Format "2006-01-02 15:04:05"}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #333; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+td, th { border: 1px solid #ccc; padding: 4px 10px; text-align: left; }
+th { background: #f4f4f4; }
+svg { display: block; margin-bottom: 2em; }
+svg text { font-size: 12px; fill: #555; }
+</style>
+</head>
+<body>
+<h1>gobench report</h1>
+
+<h2>Run</h2>
+<table>
+<tr><th>Started</th><td>{{.Report.Started.Format "2006-01-02 15:04:05 MST"}}</td></tr>
+<tr><th>Duration</th><td>{{printf "%.2f" .Report.Duration}} sec{{if .Report.Interrupted}} (interrupted){{end}}{{with .Report.Aborted}} (aborted: {{.}}){{end}}</td></tr>
+<tr><th>Method</th><td>{{.Report.Config.Method}}</td></tr>
+<tr><th>URLs</th><td>{{range .Report.Config.URLs}}{{.}}<br>{{end}}</td></tr>
+<tr><th>gobench</th><td>{{.Report.ToolVersion}}</td></tr>
+{{range .Flags}}<tr><th>-{{.Name}}</th><td>{{.Value}}</td></tr>
+{{end}}</table>
+
+<h2>Results</h2>
+<table>
+<tr><th>Requests</th><td>{{.Report.Results.Requests}}</td></tr>
+<tr><th>Successful</th><td>{{.Report.Results.Success}}</td></tr>
+<tr><th>Network failed</th><td>{{.Report.Results.NetworkFailed}}</td></tr>
+<tr><th>Bad requests failed (!2xx)</th><td>{{.Report.Results.BadFailed}}</td></tr>
+<tr><th>Successful requests rate</th><td>{{printf "%.0f" .Report.Results.Rate}} hits/sec</td></tr>
+<tr><th>Read throughput</th><td>{{printf "%.0f" .Report.Results.ReadThroughput}} bytes/sec</td></tr>
+<tr><th>Write throughput</th><td>{{printf "%.0f" .Report.Results.WriteThroughput}} bytes/sec</td></tr>
+</table>
+
+<h2>Status codes</h2>
+<table>
+<tr><th>Status</th><th>Responses</th><th>Share</th></tr>
+{{range .Statuses}}<tr><td>{{.Code}}</td><td>{{.Count}}</td><td>{{.Percent}}</td></tr>
+{{end}}</table>
+
+<h2>Latency percentiles</h2>
+<table>
+<tr><th>Mean</th><td>{{printf "%.2f" .Report.Latency.Mean}} ms</td><th>Stdev</th><td>{{printf "%.2f" .Report.Latency.StdDev}} ms</td><th>Min</th><td>{{printf "%.2f" .Report.Latency.Min}} ms</td><th>Max</th><td>{{printf "%.2f" .Report.Latency.Max}} ms</td></tr>
+</table>
+<svg width="900" height="330" viewBox="0 0 900 330">
+{{range .Ticks}}<line x1="50" x2="850" y1="{{.Y}}" y2="{{.Y}}" stroke="#eee"/><text x="44" y="{{.Y}}" text-anchor="end" dominant-baseline="middle">{{.Label}}</text>
+{{end}}{{range .Percentiles}}<rect x="{{.X}}" y="{{.Y}}" width="{{.Width}}" height="{{.Height}}" fill="#2b7bb9"><title>{{.Label}}: {{.Value}}</title></rect>
+<text x="{{.X}}" y="310">{{.Label}}</text>
+{{end}}<text x="10" y="30">ms</text>
+</svg>
+
+{{template "chart" .Throughput}}
+{{template "chart" .Latency}}
+</body>
+</html>
+{{define "chart"}}<h2>{{.Title}} over time</h2>
+<svg width="900" height="330" viewBox="0 0 900 330">
+{{range .Ticks}}<line x1="50" x2="850" y1="{{.Y}}" y2="{{.Y}}" stroke="#eee"/><text x="44" y="{{.Y}}" text-anchor="end" dominant-baseline="middle">{{.Label}}</text>
+{{end}}{{range .Series}}<polyline fill="none" stroke="{{.Color}}" stroke-width="1.5" points="{{.Points}}"/>
+<text x="{{.LegendX}}" y="30" style="fill: {{.Color}}">{{.Name}}</text>
+{{end}}<text x="50" y="310">0 s</text><text x="850" y="310" text-anchor="end">{{.Last}} s</text>
+<text x="10" y="30">{{.Unit}}</text>
+</svg>
+{{end}}`))
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/glentiki/hdrhistogram"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// quicHandshakes records how long the QUIC handshakes of -http3 took and how
+// many of the connections sent 0-RTT data the server accepted
+var quicHandshakes = struct {
+	sync.Mutex
+	latencies *hdrhistogram.Histogram
+	zeroRTT   int64
+}{}
+
+// newHTTP3Transport returns a transport that sends the requests over QUIC,
+// timing the handshake of each connection it dials. tlsConfig needs a session
+// cache for connections after the first to resume with 0-RTT.
+func newHTTP3Transport(tlsConfig *tls.Config) http.RoundTripper {
+	return zeroRTTTransport{&http3.Transport{
+		TLSClientConfig:    tlsConfig,
+		QUICConfig:         &quic.Config{HandshakeIdleTimeout: time.Duration(writeTimeout) * time.Millisecond},
+		DisableCompression: true,
+		Dial: func(ctx context.Context, addr string, tlsConfig *tls.Config, config *quic.Config) (*quic.Conn, error) {
+			start := time.Now()
+			conn, err := quic.DialAddrEarly(ctx, addr, tlsConfig, config)
+			if err != nil {
+				return nil, err
+			}
+			go func() {
+				select {
+				case <-conn.HandshakeComplete():
+					recordQUICHandshake(time.Since(start), conn.ConnectionState().Used0RTT)
+				case <-conn.Context().Done():
+				}
+			}()
+			return conn, nil
+		},
+	}}
+}
+
+// zeroRTTTransport sends GET and HEAD requests without a body, which are safe
+// for the server to see twice, in 0-RTT data on connections that resume a
+// session, rather than waiting for the handshake to finish
+type zeroRTTTransport struct {
+	*http3.Transport
+}
+
+func (t zeroRTTTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		switch req.Method {
+		case http.MethodGet, "":
+			req = req.Clone(req.Context())
+			req.Method = http3.MethodGet0RTT
+		case http.MethodHead:
+			req = req.Clone(req.Context())
+			req.Method = http3.MethodHead0RTT
+		}
+	}
+	return t.Transport.RoundTrip(req)
+}
+
+func recordQUICHandshake(d time.Duration, used0RTT bool) {
+	quicHandshakes.Lock()
+	defer quicHandshakes.Unlock()
+	if quicHandshakes.latencies == nil {
+		quicHandshakes.latencies = newLatencyHistogram(3)
+	}
+	recordLatency(quicHandshakes.latencies, latencyMicroseconds(d))
+	if used0RTT {
+		quicHandshakes.zeroRTT++
+	}
+}
+
+func resetQUICHandshakes() {
+	quicHandshakes.Lock()
+	quicHandshakes.latencies = nil
+	quicHandshakes.zeroRTT = 0
+	quicHandshakes.Unlock()
+}
+
+func printQUICHandshakes() {
+	quicHandshakes.Lock()
+	defer quicHandshakes.Unlock()
+	latencies := quicHandshakes.latencies
+	if latencies == nil {
+		return
+	}
+	fmt.Printf("QUIC handshakes:                %10d\n", latencies.TotalCount())
+	fmt.Printf("QUIC handshakes with 0-RTT:     %10d\n", quicHandshakes.zeroRTT)
+	fmt.Printf("QUIC handshake time (mean):     %10.2f ms\n", latencies.Mean()/1000)
+	fmt.Printf("QUIC handshake time (99%%):      %10.2f ms\n", latencyMilliseconds(latencies.ValueAtPercentile(99)))
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// influxExporter writes the requests, errors and latency percentiles of each
+// -influxdb-interval of a run to InfluxDB in line protocol, as a point of the
+// gobench measurement tagged with the run's -label values
+type influxExporter struct {
+	url      string
+	token    string
+	tags     string
+	interval *intervalSummary
+	posts    sync.WaitGroup
+}
+
+func newInfluxExporter(url string, token string, labels map[string]string, start time.Time) *influxExporter {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	// Influx wants tags sorted by key to avoid work on its side
+	sort.Strings(names)
+	var tags strings.Builder
+	for _, name := range names {
+		tags.WriteString("," + influxEscape(name) + "=" + influxEscape(labels[name]))
+	}
+	return &influxExporter{url: url, token: token, tags: tags.String(), interval: newIntervalSummary(start)}
+}
+
+var influxEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+func influxEscape(s string) string {
+	return influxEscaper.Replace(s)
+}
+
+func (e *influxExporter) record(res *resp) {
+	e.interval.record(res)
+}
+
+// export writes the point for the interval up to now and starts the next one
+func (e *influxExporter) export(now time.Time) {
+	s := e.interval
+	seconds := now.Sub(s.start).Seconds()
+	if seconds <= 0 {
+		return
+	}
+	line := fmt.Sprintf("gobench%s requests=%di,success=%di,errors=%di,rps=%f,p50=%f,p90=%f,p99=%f,max=%f,mean=%f %d\n",
+		e.tags, s.requests, s.success, s.failed, float64(s.success)/seconds,
+		latencyMilliseconds(s.latencies.ValueAtPercentile(50)), latencyMilliseconds(s.latencies.ValueAtPercentile(90)),
+		latencyMilliseconds(s.latencies.ValueAtPercentile(99)), latencyMilliseconds(s.latencies.Max()),
+		s.latencies.Mean()/1000, now.UnixNano())
+	s.reset(now)
+
+	e.posts.Add(1)
+	go func() {
+		defer e.posts.Done()
+		e.post(line)
+	}()
+}
+
+func (e *influxExporter) post(line string) {
+	req, err := http.NewRequest("POST", e.url, strings.NewReader(line))
+	if err != nil {
+		log.Println("Error writing to InfluxDB:", err)
+		return
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if e.token != "" {
+		req.Header.Set("Authorization", "Token "+e.token)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		if urlErr, ok := err.(*url.Error); ok {
+			urlErr.URL = redactURL(e.url)
+		}
+		log.Println("Error writing to InfluxDB:", err)
+		return
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(res.Body)
+		log.Printf("Error writing to InfluxDB: %s %s", res.Status, bytes.TrimSpace(body))
+	}
+}
+
+// close exports the last, partial, interval, if anything happened in it, and
+// waits for every point to be written
+func (e *influxExporter) close(now time.Time) {
+	if e.interval.requests > 0 {
+		e.export(now)
+	}
+	e.posts.Wait()
+}
+
+// parseLabels parses the key=value -label flags
+func parseLabels(flags []string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, label := range flags {
+		name, value, ok := strings.Cut(label, "=")
+		if !ok || name == "" || value == "" {
+			return nil, fmt.Errorf("-label %q is not key=value", label)
+		}
+		labels[name] = value
+	}
+	return labels, nil
+}
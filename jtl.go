@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// jtlColumns are the columns of JMeter's default CSV results (JTL) file
+var jtlColumns = []string{
+	"timeStamp",
+	"elapsed",
+	"label",
+	"responseCode",
+	"responseMessage",
+	"threadName",
+	"dataType",
+	"success",
+	"failureMessage",
+	"bytes",
+	"sentBytes",
+	"grpThreads",
+	"allThreads",
+	"URL",
+	"Latency",
+	"IdleTime",
+	"Connect",
+}
+
+// jtlLog writes every request of a run as a row of a JMeter CSV results file,
+// so that JMeter's listeners and the tools built around them can read it
+type jtlLog struct {
+	file   *os.File
+	buffer *bufio.Writer
+	writer *csv.Writer
+}
+
+func newJTLLog(fileName string) (*jtlLog, error) {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return nil, err
+	}
+	buffer := bufio.NewWriter(file)
+	l := &jtlLog{file: file, buffer: buffer, writer: csv.NewWriter(buffer)}
+	l.writer.Write(jtlColumns)
+	return l, nil
+}
+
+func (l *jtlLog) record(res *resp) error {
+	label := res.endpoint
+	if res.operation != "" {
+		label = res.operation
+	}
+	if label == "" {
+		label = res.url
+	}
+	code := strconv.Itoa(res.status)
+	message := http.StatusText(res.status)
+	if res.status == 0 {
+		// As JMeter reports requests that got no response
+		code = "Non HTTP response code: " + res.errorKind
+		message = "Non HTTP response message: " + res.err
+	}
+	success := res.status >= 200 && res.status < 400 && !res.tooSlow
+	threads := strconv.Itoa(clients)
+	// JMeter's elapsed is up to the end of the body and its latency up to
+	// the first byte
+	elapsed := res.complete
+	if elapsed == 0 {
+		elapsed = res.latency
+	}
+	l.writer.Write([]string{
+		strconv.FormatInt(res.sent.UnixNano()/1e6, 10),
+		strconv.FormatInt(elapsed/1000, 10),
+		label,
+		code,
+		message,
+		"gobench",
+		"text",
+		strconv.FormatBool(success),
+		"",
+		strconv.Itoa(res.size),
+		"0",
+		threads,
+		threads,
+		res.url,
+		strconv.FormatInt(res.latency/1000, 10),
+		"0",
+		"0",
+	})
+	return l.writer.Error()
+}
+
+func (l *jtlLog) close() error {
+	l.writer.Flush()
+	if err := l.buffer.Flush(); err != nil {
+		l.file.Close()
+		return err
+	}
+	return l.file.Close()
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sync/atomic"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// keepAliveSummary is what -compare-keepalive compares between the two runs
+type keepAliveSummary struct {
+	requests    int64
+	rate        float64
+	mean        float64
+	p50         float64
+	p99         float64
+	connections int64
+	handshakes  int64
+	written     int64
+}
+
+// withKeepAlive returns a copy of the configuration with its own transport,
+// set to use keep-alive or not
+func (c *Configuration) withKeepAlive(keepAlive bool) *Configuration {
+	clone := *c
+	clone.keepAlive = keepAlive
+	transport := c.myClient.Transport.(*http.Transport).Clone()
+	transport.DisableKeepAlives = !keepAlive
+	clone.myClient = &http.Client{Transport: transport, Timeout: c.myClient.Timeout}
+	return &clone
+}
+
+func summarizeKeepAlive(stats *runStats) keepAliveSummary {
+	sum := total(stats.results)
+	return keepAliveSummary{
+		requests:    sum.requests,
+		rate:        float64(sum.success) / stats.elapsed.Seconds(),
+		mean:        stats.collector.latencies.Mean() / 1000,
+		p50:         latencyMilliseconds(stats.collector.latencies.ValueAtPercentile(50)),
+		p99:         latencyMilliseconds(stats.collector.latencies.ValueAtPercentile(99)),
+		connections: atomic.LoadInt64(&ipv4Connections) + atomic.LoadInt64(&ipv6Connections),
+		handshakes:  atomic.LoadInt64(&fullHandshakes) + atomic.LoadInt64(&resumedHandshakes),
+		written:     atomic.LoadInt64(&writeThroughput),
+	}
+}
+
+// perRequest divides a count over the requests it was spent on
+func perRequest(count int64, requests int64) float64 {
+	if requests == 0 {
+		return 0
+	}
+	return float64(count) / float64(requests)
+}
+
+// compareKeepAlives runs the workload with keep-alive and then without it and
+// prints the two side by side, including what each request pays for not
+// reusing its connection.
+func compareKeepAlives(configuration *Configuration, signalChan chan os.Signal) {
+	var summaries [2]keepAliveSummary
+	for i, keepAlive := range []bool{true, false} {
+		if keepAlive {
+			fmt.Println("Run 1 of 2: with keep-alive")
+		} else {
+			fmt.Println("Run 2 of 2: without keep-alive")
+		}
+		stats := run(configuration.withKeepAlive(keepAlive), signalChan, nil)
+		summaries[i] = summarizeKeepAlive(stats)
+		if stats.interrupted {
+			fmt.Println("Interrupted, not comparing")
+			return
+		}
+	}
+
+	on, off := summaries[0], summaries[1]
+	fmt.Println("")
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetRowSeparator("-")
+	table.SetHeader([]string{"", "Keep-alive", "No keep-alive", "Difference"})
+	table.Append([]string{"Requests", fmt.Sprintf("%d", on.requests), fmt.Sprintf("%d", off.requests), fmt.Sprintf("%+d", off.requests-on.requests)})
+	table.Append([]string{"Successful rate", fmt.Sprintf("%.0f hits/sec", on.rate), fmt.Sprintf("%.0f hits/sec", off.rate), fmt.Sprintf("%+.0f hits/sec", off.rate-on.rate)})
+	table.Append([]string{"Avg latency", fmt.Sprintf("%.2f ms", on.mean), fmt.Sprintf("%.2f ms", off.mean), fmt.Sprintf("%+.2f ms", off.mean-on.mean)})
+	table.Append([]string{"50% latency", fmt.Sprintf("%.2f ms", on.p50), fmt.Sprintf("%.2f ms", off.p50), fmt.Sprintf("%+.2f ms", off.p50-on.p50)})
+	table.Append([]string{"99% latency", fmt.Sprintf("%.2f ms", on.p99), fmt.Sprintf("%.2f ms", off.p99), fmt.Sprintf("%+.2f ms", off.p99-on.p99)})
+	table.Append([]string{"Connections", fmt.Sprintf("%d", on.connections), fmt.Sprintf("%d", off.connections), fmt.Sprintf("%+d", off.connections-on.connections)})
+	table.Append([]string{"Connections per request", fmt.Sprintf("%.3f", perRequest(on.connections, on.requests)), fmt.Sprintf("%.3f", perRequest(off.connections, off.requests)), ""})
+	table.Append([]string{"TLS handshakes per request", fmt.Sprintf("%.3f", perRequest(on.handshakes, on.requests)), fmt.Sprintf("%.3f", perRequest(off.handshakes, off.requests)), ""})
+	table.Append([]string{"Bytes written per request", fmt.Sprintf("%.0f", perRequest(on.written, on.requests)), fmt.Sprintf("%.0f", perRequest(off.written, off.requests)), ""})
+	table.Render()
+	fmt.Println("")
+	fmt.Printf("Connection setup overhead:      %10.2f ms/request\n", off.mean-on.mean)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/glentiki/hdrhistogram"
+)
+
+// Latencies are recorded in microseconds, so that the requests to a fast
+// service don't all land in the same millisecond, and shown in milliseconds.
+
+func newLatencyHistogram(sigfigs int) *hdrhistogram.Histogram {
+	return hdrhistogram.New(1, latencyMicroseconds(histogramMax), sigfigs)
+}
+
+// recordLatency adds a latency to h, counting one beyond -histogram-max as
+// -histogram-max rather than losing it. It returns false if it had to.
+func recordLatency(h *hdrhistogram.Histogram, latency int64) bool {
+	if latency > h.HighestTrackableValue() {
+		h.RecordValue(h.HighestTrackableValue())
+		return false
+	}
+	h.RecordValue(latency)
+	return true
+}
+
+// latencyMicroseconds is d as a latency to record
+func latencyMicroseconds(d time.Duration) int64 {
+	return int64(d / time.Microsecond)
+}
+
+// latencyMilliseconds converts a latency in microseconds to milliseconds
+func latencyMilliseconds(us int64) float64 {
+	return float64(us) / 1000
+}
+
+// formatLatency formats a latency in microseconds in milliseconds
+func formatLatency(us int64) string {
+	return fmt.Sprintf("%.2f ms", latencyMilliseconds(us))
+}
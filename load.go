@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/glentiki/hdrhistogram"
+	"github.com/olekukonko/tablewriter"
+)
+
+// dutyCycle alternates between sending at full rate for on and staying idle
+// for off, starting with an on phase when the run starts.
+type dutyCycle struct {
+	start time.Time
+	on    time.Duration
+	off   time.Duration
+}
+
+// wait blocks while the cycle is in an idle phase and returns the index of the
+// cycle whose on phase is current. It returns early if ctx is done.
+func (d *dutyCycle) wait(ctx context.Context) int {
+	period := d.on + d.off
+	for {
+		elapsed := time.Since(d.start)
+		position := elapsed % period
+		if position < d.on || !sleep(ctx, period-position) {
+			return int(elapsed / period)
+		}
+	}
+}
+
+// onTime returns how long the on phase of cycle had lasted by now.
+func (d *dutyCycle) onTime(cycle int, now time.Time) time.Duration {
+	cycleStart := d.start.Add(time.Duration(cycle) * (d.on + d.off))
+	if elapsed := now.Sub(cycleStart); elapsed < d.on {
+		return elapsed
+	}
+	return d.on
+}
+
+// pacer spaces out request start times across all clients so that together
+// they follow rate, in requests per second, as it changes over the run. The
+// schedule doesn't wait for responses, so requests are sent late only when
+// every client is busy.
+type pacer struct {
+	sync.Mutex
+	start time.Time
+	next  time.Time
+	rate  func(elapsed time.Duration) float64
+
+	// poisson spaces requests at random, as arrivals of a Poisson process,
+	// rather than evenly
+	poisson bool
+}
+
+func newPacer(rate func(elapsed time.Duration) float64) *pacer {
+	now := time.Now()
+	return &pacer{start: now, next: now, rate: rate, poisson: arrivals == "poisson"}
+}
+
+// reset starts the pacer's schedule again from start
+func (p *pacer) reset(start time.Time) {
+	p.Lock()
+	p.start = start
+	p.next = start
+	p.Unlock()
+}
+
+// wait blocks until the next request is due, or ctx is done, and returns the
+// time it was due.
+func (p *pacer) wait(ctx context.Context) time.Time {
+	for {
+		p.Lock()
+		due := p.next
+		rate := p.rate(due.Sub(p.start))
+		if rate > 0 {
+			interval := float64(time.Second) / rate
+			if p.poisson {
+				interval *= rand.ExpFloat64()
+			}
+			p.next = due.Add(time.Duration(interval))
+			p.Unlock()
+			sleep(ctx, time.Until(due))
+			return due
+		}
+		// Nothing should be sent at a zero rate, so look again a little
+		// later, moving the schedule on no faster than time passes
+		if !due.After(time.Now()) {
+			p.next = due.Add(100 * time.Millisecond)
+		}
+		p.Unlock()
+		if !sleep(ctx, 100*time.Millisecond) {
+			return due
+		}
+	}
+}
+
+// sleep pauses for d and reports whether it did so without ctx being done.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// sineRate returns a rate that swings by amplitude (a fraction of mean) either
+// side of mean over each period, rising from mean at the start of the run.
+func sineRate(mean float64, amplitude float64, period time.Duration) func(elapsed time.Duration) float64 {
+	return func(elapsed time.Duration) float64 {
+		return mean * (1 + amplitude*math.Sin(2*math.Pi*elapsed.Seconds()/period.Seconds()))
+	}
+}
+
+type cycleResult struct {
+	requests  int64
+	success   int64
+	failed    int64
+	first     int64
+	latencies *hdrhistogram.Histogram
+}
+
+// recordCycle adds a response to the statistics of the cycle it was sent in,
+// growing cycles as new cycles start.
+func recordCycle(cycles []*cycleResult, res *resp) []*cycleResult {
+	for len(cycles) <= res.cycle {
+		// Fewer significant figures than the run-wide histogram keep long
+		// runs with many short cycles from using a lot of memory
+		cycles = append(cycles, &cycleResult{first: -1, latencies: newLatencyHistogram(3)})
+	}
+	cycle := cycles[res.cycle]
+	cycle.requests++
+	if res.status >= 200 && res.status < 300 && !res.tooSlow {
+		cycle.success++
+		recordLatency(cycle.latencies, res.latency)
+	} else {
+		cycle.failed++
+	}
+	if cycle.first < 0 {
+		cycle.first = res.latency
+	}
+	return cycles
+}
+
+func printCycles(cycles []*cycleResult, duty *dutyCycle) {
+	now := time.Now()
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetRowSeparator("-")
+	table.SetHeader([]string{
+		"Cycle",
+		"Requests",
+		"Success",
+		"Failed",
+		"Rate",
+		"First",
+		"50%",
+		"99%",
+		"Max",
+	})
+	for i, cycle := range cycles {
+		onTime := duty.onTime(i, now).Seconds()
+		if onTime <= 0 {
+			onTime = 1
+		}
+		table.Append([]string{
+			bold(fmt.Sprintf("%d", i+1)),
+			fmt.Sprintf("%d", cycle.requests),
+			fmt.Sprintf("%d", cycle.success),
+			fmt.Sprintf("%d", cycle.failed),
+			fmt.Sprintf("%.0f hits/sec", float64(cycle.success)/onTime),
+			formatLatency(cycle.first),
+			formatLatency(cycle.latencies.ValueAtPercentile(50)),
+			formatLatency(cycle.latencies.ValueAtPercentile(99)),
+			formatLatency(cycle.latencies.Max()),
+		})
+	}
+	table.Render()
+	fmt.Println("")
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// malformedRequests are the boundary-condition requests sent by -malformed.
+// Each builds a raw HTTP/1.1 request for a path and Host header, since
+// net/http refuses to send most of them.
+var malformedRequests = []struct {
+	name  string
+	build func(path string, host string) string
+}{
+	{"oversized header", func(path string, host string) string {
+		return rawRequest("GET", path, host, []string{"X-Oversized: " + strings.Repeat("a", 64<<10)}, "")
+	}},
+	{"many headers", func(path string, host string) string {
+		headers := make([]string, 1000)
+		for i := range headers {
+			headers[i] = fmt.Sprintf("X-Header-%d: %d", i, i)
+		}
+		return rawRequest("GET", path, host, headers, "")
+	}},
+	{"long path", func(path string, host string) string {
+		return rawRequest("GET", path+"/"+strings.Repeat("a", 16<<10), host, nil, "")
+	}},
+	{"encoded control characters in path", func(path string, host string) string {
+		return rawRequest("GET", path+"%00%0d%0a%7f", host, nil, "")
+	}},
+	{"raw non-ASCII in path", func(path string, host string) string {
+		return rawRequest("GET", path+"\xff\xfe\xc3\x28", host, nil, "")
+	}},
+	{"path traversal", func(path string, host string) string {
+		return rawRequest("GET", "/../../../../etc/passwd", host, nil, "")
+	}},
+	{"invalid header name", func(path string, host string) string {
+		return rawRequest("GET", path, host, []string{"Bad Header(): x"}, "")
+	}},
+	{"negative Content-Length", func(path string, host string) string {
+		return rawRequest("POST", path, host, []string{"Content-Length: -1"}, "")
+	}},
+	{"huge Content-Length", func(path string, host string) string {
+		return rawRequest("POST", path, host, []string{"Content-Length: 99999999999999999999"}, "")
+	}},
+	{"conflicting Content-Length", func(path string, host string) string {
+		return rawRequest("POST", path, host, []string{"Content-Length: 5", "Content-Length: 6"}, "hello")
+	}},
+	{"Content-Length with chunked", func(path string, host string) string {
+		return rawRequest("POST", path, host, []string{"Content-Length: 5", "Transfer-Encoding: chunked"}, "0\r\n\r\n")
+	}},
+}
+
+// rawRequest assembles an HTTP/1.1 request without any validation
+func rawRequest(method string, target string, host string, headers []string, body string) string {
+	var b strings.Builder
+	b.WriteString(method + " " + target + " HTTP/1.1\r\n")
+	b.WriteString("Host: " + host + "\r\n")
+	for _, header := range headers {
+		b.WriteString(header + "\r\n")
+	}
+	b.WriteString("Connection: close\r\n\r\n")
+	b.WriteString(body)
+	return b.String()
+}
+
+// sendMalformed sends a randomly chosen malformed request to the host of
+// target on a connection of its own and records how the server reacted.
+func (w *worker) sendMalformed(target string) {
+	kind := malformedRequests[rand.Intn(len(malformedRequests))]
+	outcome := w.exchangeRaw(target, kind.build)
+	w.batch.add(resp{malformed: kind.name, outcome: outcome})
+}
+
+func (w *worker) exchangeRaw(target string, build func(path string, host string) string) string {
+	u, err := url.Parse(target)
+	if err != nil {
+		return "error"
+	}
+	host := u.Host
+	if hostHeader != "" {
+		host = hostHeader
+	}
+	address := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+		address = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	transport := w.configuration.myClient.Transport.(*http.Transport)
+	conn, err := transport.DialContext(w.ctx, "tcp", address)
+	if err != nil {
+		return outcomeOf(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Duration(readTimeout) * time.Millisecond))
+	if u.Scheme == "https" {
+		config := transport.TLSClientConfig.Clone()
+		if config.ServerName == "" {
+			config.ServerName = u.Hostname()
+		}
+		config.NextProtos = []string{"http/1.1"}
+		tlsConn := tls.Client(conn, config)
+		if err := tlsConn.HandshakeContext(w.ctx); err != nil {
+			return outcomeOf(err)
+		}
+		conn = tlsConn
+	}
+
+	if _, err := io.WriteString(conn, build(u.RequestURI(), host)); err != nil {
+		return outcomeOf(err)
+	}
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		return outcomeOf(err)
+	}
+	res.Body.Close()
+	return fmt.Sprintf("%d", res.StatusCode)
+}
+
+// outcomeOf describes a failed malformed request
+func outcomeOf(err error) string {
+	var netErr net.Error
+	switch {
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, net.ErrClosed):
+		return "connection closed"
+	case strings.Contains(err.Error(), "connection reset"):
+		return "connection reset"
+	}
+	return "error"
+}
+
+// printMalformed prints how the server responded to each kind of malformed
+// request
+func printMalformed(outcomes map[[2]string]int64) {
+	keys := make([][2]string, 0, len(outcomes))
+	for key := range outcomes {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetRowSeparator("-")
+	table.SetHeader([]string{"Malformed request", "Response", "Count"})
+	for _, key := range keys {
+		table.Append([]string{key[0], key[1], fmt.Sprintf("%d", outcomes[key])})
+	}
+	table.Render()
+	fmt.Println("")
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/glentiki/hdrhistogram"
+)
+
+// mergeCommand combines the reports of runs that went on at the same time,
+// such as those of the agents of a distributed run, into one report
+func mergeCommand(args []string) {
+	flags := flag.NewFlagSet("merge", flag.ExitOnError)
+	output := flags.String("o", "", "Write the merged report to this file rather than to stdout")
+	flags.Parse(args)
+	if flags.NArg() < 2 {
+		fmt.Println("Usage: gobench merge [-o FILE] REPORT REPORT...")
+		flags.PrintDefaults()
+		os.Exit(1)
+	}
+
+	reports := make([]*jsonReport, flags.NArg())
+	for i, fileName := range flags.Args() {
+		var err error
+		if reports[i], err = readReport(fileName); err != nil {
+			log.Fatalf("Error reading report %s: %s", fileName, err)
+		}
+	}
+	merged, err := mergeReports(reports)
+	if err != nil {
+		log.Fatalf("Error merging reports: %s", err)
+	}
+	data, err := marshalReport(merged)
+	if err != nil {
+		log.Fatalf("Error encoding the merged report: %s", err)
+	}
+	if *output == "" {
+		os.Stdout.Write(data)
+		return
+	}
+	if err := ioutil.WriteFile(*output, data, 0644); err != nil {
+		log.Fatalf("Error writing %s: %s", *output, err)
+	}
+}
+
+// mergeReports combines reports into the report of one run from the first
+// start to the last finish. Counts add up and latencies and sizes are merged
+// from their histograms, so the reports must have been written with them. The
+// configuration is that of the first report.
+func mergeReports(reports []*jsonReport) (*jsonReport, error) {
+	first := reports[0]
+	merged := &jsonReport{
+		Schema:        reportSchema,
+		SchemaVersion: reportVersion,
+		ToolVersion:   version,
+		Started:       first.Started,
+		Finished:      first.Finished,
+		Config:        first.Config,
+		StatusCodes:   make(map[string]int64),
+		ResponseSizes: make(map[string]reportSizes),
+	}
+	merged.Config.URLs = nil
+	seen := make(map[string]bool)
+	var readBytes, writeBytes float64
+	slowest := 0
+	for _, r := range reports {
+		if r.Started.Before(merged.Started) {
+			merged.Started = r.Started
+		}
+		if r.Finished.After(merged.Finished) {
+			merged.Finished = r.Finished
+		}
+		merged.Interrupted = merged.Interrupted || r.Interrupted
+		if merged.Aborted == "" {
+			merged.Aborted = r.Aborted
+		}
+		for _, url := range r.Config.URLs {
+			if !seen[url] {
+				seen[url] = true
+				merged.Config.URLs = append(merged.Config.URLs, url)
+			}
+		}
+
+		results := &merged.Results
+		results.Requests += r.Results.Requests
+		results.Success += r.Results.Success
+		results.NetworkFailed += r.Results.NetworkFailed
+		results.BadFailed += r.Results.BadFailed
+		results.TooSlow += r.Results.TooSlow
+		results.Retries += r.Results.Retries
+		results.Replayed += r.Results.Replayed
+		results.RateLimited += r.Results.RateLimited
+		results.RateLimitDelay += r.Results.RateLimitDelay
+		results.BreakerOpened += r.Results.BreakerOpened
+		results.BreakerHalfOpened += r.Results.BreakerHalfOpened
+		results.BreakerClosed += r.Results.BreakerClosed
+		results.IPv4Connections += r.Results.IPv4Connections
+		results.IPv6Connections += r.Results.IPv6Connections
+		results.FullHandshakes += r.Results.FullHandshakes
+		results.ResumedHandshakes += r.Results.ResumedHandshakes
+		results.GzipResponses += r.Results.GzipResponses
+		results.CompressedBytes += r.Results.CompressedBytes
+		results.DecompressedBytes += r.Results.DecompressedBytes
+		readBytes += r.Results.ReadThroughput * r.Duration
+		writeBytes += r.Results.WriteThroughput * r.Duration
+
+		for status, count := range r.StatusCodes {
+			merged.StatusCodes[status] += count
+		}
+		for kind, count := range r.NetworkErrors {
+			if merged.NetworkErrors == nil {
+				merged.NetworkErrors = make(map[string]int64)
+			}
+			merged.NetworkErrors[kind] += count
+		}
+		merged.Slowest = append(merged.Slowest, r.Slowest...)
+		if len(r.Slowest) > slowest {
+			slowest = len(r.Slowest)
+		}
+	}
+	merged.Duration = merged.Finished.Sub(merged.Started).Seconds()
+	seconds := merged.Duration
+	if seconds <= 0 {
+		seconds = 1
+	}
+	merged.Results.Rate = float64(merged.Results.Success) / seconds
+	merged.Results.ReadThroughput = readBytes / seconds
+	merged.Results.WriteThroughput = writeBytes / seconds
+
+	sort.Slice(merged.Slowest, func(i, j int) bool { return merged.Slowest[i].Latency > merged.Slowest[j].Latency })
+	if len(merged.Slowest) > slowest {
+		merged.Slowest = merged.Slowest[:slowest]
+	}
+	for i := range merged.Slowest {
+		merged.Slowest[i].Offset = float64(merged.Slowest[i].Sent.Sub(merged.Started)) / float64(time.Millisecond)
+	}
+
+	var err error
+	latency := func(field func(r *jsonReport) *reportLatency) *reportLatency {
+		var histograms []*reportHistogram
+		for _, r := range reports {
+			if latency := field(r); latency != nil {
+				histograms = append(histograms, latency.Histogram)
+			}
+		}
+		h, e := mergeHistograms(histograms)
+		if h == nil {
+			if err == nil {
+				err = e
+			}
+			return nil
+		}
+		latency := newReportLatency(h)
+		return &latency
+	}
+	if l := latency(func(r *jsonReport) *reportLatency { return &r.Latency }); l != nil {
+		merged.Latency = *l
+	}
+	if l := latency(func(r *jsonReport) *reportLatency { return &r.CompleteLatency }); l != nil {
+		merged.CompleteLatency = *l
+	}
+	merged.CorrectedLatency = latency(func(r *jsonReport) *reportLatency { return r.CorrectedLatency })
+	merged.TLSHandshakeTime = latency(func(r *jsonReport) *reportLatency { return r.TLSHandshakeTime })
+	merged.Non2xxLatency = latency(func(r *jsonReport) *reportLatency { return r.Non2xxLatency })
+	merged.FailedLatency = latency(func(r *jsonReport) *reportLatency { return r.FailedLatency })
+	merged.SlowLatency = latency(func(r *jsonReport) *reportLatency { return r.SlowLatency })
+
+	for _, r := range reports {
+		for url := range r.URLs {
+			if _, ok := merged.URLs[url]; ok {
+				continue
+			}
+			if merged.URLs == nil {
+				merged.URLs = make(map[string]reportURL)
+			}
+			total := reportURL{}
+			for _, other := range reports {
+				if result, ok := other.URLs[url]; ok {
+					total.Requests += result.Requests
+					total.Success += result.Success
+					total.Failed += result.Failed
+				}
+			}
+			if l := latency(func(r *jsonReport) *reportLatency {
+				if result, ok := r.URLs[url]; ok {
+					return &result.Latency
+				}
+				return nil
+			}); l != nil {
+				total.Latency = *l
+			}
+			merged.URLs[url] = total
+		}
+	}
+
+	for _, r := range reports {
+		for class := range r.ResponseSizes {
+			if _, ok := merged.ResponseSizes[class]; ok {
+				continue
+			}
+			var histograms []*reportHistogram
+			var bytes int64
+			for _, other := range reports {
+				if sizes, ok := other.ResponseSizes[class]; ok {
+					histograms = append(histograms, sizes.Histogram)
+					bytes += sizes.Total
+				}
+			}
+			h, e := mergeHistograms(histograms)
+			if h == nil {
+				if err == nil {
+					err = e
+				}
+				continue
+			}
+			merged.ResponseSizes[class] = newReportSizes(h, bytes)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
+
+// mergeHistograms returns the histograms added together, or nil and why not
+func mergeHistograms(histograms []*reportHistogram) (*hdrhistogram.Histogram, error) {
+	var merged *hdrhistogram.Histogram
+	for _, r := range histograms {
+		if r == nil {
+			return nil, fmt.Errorf("a report has no histograms to merge, as it was written by an older gobench")
+		}
+		h, err := r.histogram()
+		if err != nil {
+			return nil, err
+		}
+		if merged == nil {
+			merged = h
+		} else {
+			merged.Merge(h)
+		}
+	}
+	return merged, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// mixOperation is one kind of request in a -mix, sent in proportion to its
+// weight
+type mixOperation struct {
+	name   string
+	method string
+	url    string
+	weight int
+}
+
+// trafficMix is a weighted blend of operations that each request is picked
+// from
+type trafficMix struct {
+	operations []*mixOperation
+	total      int
+}
+
+// parseMix parses a -mix flag such as GET:/list=80,POST:/create=20. Paths
+// starting with / are appended to base, the -u URL; anything else must be a
+// full URL.
+func parseMix(spec string, base string) (*trafficMix, error) {
+	if base != "" {
+		u, err := url.Parse(base)
+		if err != nil {
+			return nil, err
+		}
+		u.RawQuery = ""
+		u.Fragment = ""
+		base = strings.TrimSuffix(u.String(), "/")
+	}
+	m := &trafficMix{}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		i := strings.LastIndex(item, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("%q is not METHOD:PATH=WEIGHT", item)
+		}
+		weight, err := strconv.Atoi(item[i+1:])
+		if err != nil || weight <= 0 {
+			return nil, fmt.Errorf("%q needs a positive weight", item)
+		}
+		method, path, ok := strings.Cut(item[:i], ":")
+		if !ok || !validMethod(method) || path == "" {
+			return nil, fmt.Errorf("%q is not METHOD:PATH=WEIGHT", item)
+		}
+		target := path
+		if strings.HasPrefix(path, "/") {
+			if base == "" {
+				return nil, fmt.Errorf("%q has a path but there is no -u URL to add it to", item)
+			}
+			target = base + path
+		}
+		m.operations = append(m.operations, &mixOperation{
+			name:   method + " " + path,
+			method: method,
+			url:    target,
+			weight: weight,
+		})
+		m.total += weight
+	}
+	return m, nil
+}
+
+// urls returns the URL of each operation
+func (m *trafficMix) urls() []string {
+	urls := make([]string, len(m.operations))
+	for i, op := range m.operations {
+		urls[i] = op.url
+	}
+	return urls
+}
+
+// pick returns the index of an operation chosen at random by weight
+func (m *trafficMix) pick() int {
+	n := rand.Intn(m.total)
+	for i, op := range m.operations {
+		if n < op.weight {
+			return i
+		}
+		n -= op.weight
+	}
+	return len(m.operations) - 1
+}
+
+// configurations returns a copy of configuration for each operation, with
+// the operation's method. Only POST, PUT and PATCH operations send the body.
+func (m *trafficMix) configurations(configuration *Configuration) []*Configuration {
+	configurations := make([]*Configuration, len(m.operations))
+	for i, op := range m.operations {
+		c := *configuration
+		c.method = op.method
+		switch op.method {
+		case "POST", "PUT", "PATCH":
+		default:
+			c.payloads = nil
+			c.streamed = nil
+			c.postDataType = ""
+		}
+		configurations[i] = &c
+	}
+	return configurations
+}
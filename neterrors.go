@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// Kinds of network failure
+const (
+	errorTimeout = "timeout"
+	errorDNS     = "DNS lookup failed"
+	errorRefused = "connection refused"
+	errorReset   = "connection reset"
+	errorClosed  = "connection closed early"
+	errorTLS     = "TLS handshake failed"
+	errorOther   = "other"
+)
+
+// classifyError returns the kind of network failure err is
+func classifyError(err error) string {
+	var netErr net.Error
+	var dnsErr *net.DNSError
+	var recordErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	var verifyErr *tls.CertificateVerificationError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	var echErr *tls.ECHRejectionError
+	switch {
+	case errors.As(err, &dnsErr):
+		return errorDNS
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return errorTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return errorRefused
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		return errorReset
+	case errors.As(err, &recordErr), errors.As(err, &alertErr), errors.As(err, &verifyErr),
+		errors.As(err, &authorityErr), errors.As(err, &hostnameErr), errors.As(err, &invalidErr),
+		errors.As(err, &echErr), strings.Contains(err.Error(), "tls: "),
+		// net/http replaces the RecordHeaderError of a plain HTTP server
+		strings.Contains(err.Error(), "server gave HTTP response to HTTPS client"):
+		return errorTLS
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return errorClosed
+	}
+	return errorOther
+}
+
+// printNetworkErrors prints how many requests failed with each kind of
+// network failure
+func printNetworkErrors(kinds map[string]int64) {
+	names := make([]string, 0, len(kinds))
+	for name := range kinds {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if kinds[names[i]] != kinds[names[j]] {
+			return kinds[names[i]] > kinds[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetRowSeparator("-")
+	table.SetHeader([]string{"Network failure", "Requests"})
+	for _, name := range names {
+		table.Append([]string{name, fmt.Sprintf("%d", kinds[name])})
+	}
+	table.Render()
+	fmt.Println("")
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// otlpBatchSize is how many spans are sent to the collector at once
+const otlpBatchSize = 512
+
+// The OTLP/HTTP JSON encoding, as far as gobench uses it. 64 bit integers
+// are strings in it and trace and span IDs are hex.
+type otlpValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID    string          `json:"traceId"`
+	SpanID     string          `json:"spanId"`
+	Name       string          `json:"name"`
+	Kind       int             `json:"kind"`
+	Start      string          `json:"startTimeUnixNano"`
+	End        string          `json:"endTimeUnixNano"`
+	Attributes []otlpAttribute `json:"attributes"`
+	Status     struct {
+		Code int `json:"code,omitempty"`
+	} `json:"status"`
+}
+
+type otlpDataPoint struct {
+	Attributes []otlpAttribute `json:"attributes,omitempty"`
+	Start      string          `json:"startTimeUnixNano,omitempty"`
+	Time       string          `json:"timeUnixNano"`
+	AsInt      *string         `json:"asInt,omitempty"`
+	AsDouble   *float64        `json:"asDouble,omitempty"`
+}
+
+type otlpSum struct {
+	DataPoints             []otlpDataPoint `json:"dataPoints"`
+	AggregationTemporality int             `json:"aggregationTemporality"`
+	IsMonotonic            bool            `json:"isMonotonic"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpDataPoint `json:"dataPoints"`
+}
+
+type otlpMetric struct {
+	Name  string     `json:"name"`
+	Unit  string     `json:"unit"`
+	Sum   *otlpSum   `json:"sum,omitempty"`
+	Gauge *otlpGauge `json:"gauge,omitempty"`
+}
+
+const (
+	otlpSpanKindClient        = 3
+	otlpStatusError           = 2
+	otlpTemporalityCumulative = 2
+)
+
+func otlpString(key string, value string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: otlpValue{StringValue: &value}}
+}
+
+func otlpInt(key string, value int64) otlpAttribute {
+	s := strconv.FormatInt(value, 10)
+	return otlpAttribute{Key: key, Value: otlpValue{IntValue: &s}}
+}
+
+func otlpTime(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// newTraceID returns a random ID for the trace of a request and its retries
+func newTraceID() string {
+	var id [16]byte
+	rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
+
+// newSpanID returns a random ID for one attempt at a request
+func newSpanID() string {
+	var id [8]byte
+	rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
+
+// otlpExporter sends the metrics of a run, and with -otlp-traces a client
+// span for every request, to an OpenTelemetry collector over OTLP/HTTP.
+// Requests carry a W3C traceparent header with their span's IDs so that the
+// server's spans join the same traces.
+type otlpExporter struct {
+	endpoint string
+	resource otlpResource
+	start    time.Time
+	spans    []otlpSpan
+	posts    sync.WaitGroup
+}
+
+func newOTLPExporter(endpoint string, labels map[string]string, start time.Time) *otlpExporter {
+	e := &otlpExporter{endpoint: strings.TrimSuffix(endpoint, "/"), start: start}
+	e.resource.Attributes = []otlpAttribute{
+		otlpString("service.name", "gobench"),
+		otlpString("service.version", version),
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		e.resource.Attributes = append(e.resource.Attributes, otlpString(name, labels[name]))
+	}
+	return e
+}
+
+// record adds the span of a traced request, sending the spans when there's
+// a batch of them
+func (e *otlpExporter) record(res *resp) {
+	if res.traceID == "" {
+		return
+	}
+	span := otlpSpan{
+		TraceID: res.traceID,
+		SpanID:  res.spanID,
+		Name:    res.method,
+		Kind:    otlpSpanKindClient,
+		Start:   otlpTime(res.sent),
+		End:     otlpTime(res.received),
+		Attributes: []otlpAttribute{
+			otlpString("http.request.method", res.method),
+			otlpString("url.full", res.url),
+		},
+	}
+	if res.status != 0 {
+		span.Attributes = append(span.Attributes, otlpInt("http.response.status_code", int64(res.status)))
+	}
+	if res.status == 0 {
+		span.Attributes = append(span.Attributes, otlpString("error.type", res.err))
+		span.Status.Code = otlpStatusError
+	} else if res.status >= 400 {
+		span.Attributes = append(span.Attributes, otlpString("error.type", strconv.Itoa(res.status)))
+		span.Status.Code = otlpStatusError
+	}
+	e.spans = append(e.spans, span)
+	if len(e.spans) >= otlpBatchSize {
+		e.flush()
+	}
+}
+
+// flush sends the spans recorded so far
+func (e *otlpExporter) flush() {
+	if len(e.spans) == 0 {
+		return
+	}
+	e.post("/v1/traces", map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource":   e.resource,
+			"scopeSpans": []interface{}{map[string]interface{}{"scope": otlpScope{Name: "gobench", Version: version}, "spans": e.spans}},
+		}},
+	})
+	e.spans = nil
+}
+
+// close sends the remaining spans and the metrics of the run in c, and waits
+// for everything to be sent
+func (e *otlpExporter) close(c *collector, now time.Time) {
+	e.flush()
+
+	start, end := otlpTime(e.start), otlpTime(now)
+	sum := func(name string, unit string, value int64) otlpMetric {
+		s := strconv.FormatInt(value, 10)
+		return otlpMetric{Name: name, Unit: unit, Sum: &otlpSum{
+			DataPoints:             []otlpDataPoint{{Start: start, Time: end, AsInt: &s}},
+			AggregationTemporality: otlpTemporalityCumulative,
+			IsMonotonic:            true,
+		}}
+	}
+	gauge := func(name string, unit string, points ...otlpDataPoint) otlpMetric {
+		return otlpMetric{Name: name, Unit: unit, Gauge: &otlpGauge{DataPoints: points}}
+	}
+	point := func(value float64, attributes ...otlpAttribute) otlpDataPoint {
+		return otlpDataPoint{Attributes: attributes, Time: end, AsDouble: &value}
+	}
+
+	var latencies []otlpDataPoint
+	for _, percentile := range reportPercentiles {
+		latencies = append(latencies, point(latencyMilliseconds(c.latencies.ValueAtPercentile(percentile)),
+			otlpString("percentile", strconv.FormatFloat(percentile, 'f', -1, 64))))
+	}
+	metrics := []otlpMetric{
+		sum("gobench.requests", "{request}", c.responses),
+		sum("gobench.errors", "{request}", c.errors),
+		gauge("gobench.rate", "{request}/s", point(float64(c.messageCount)/now.Sub(e.start).Seconds())),
+		gauge("gobench.latency", "ms", latencies...),
+		gauge("gobench.latency.mean", "ms", point(c.latencies.Mean()/1000)),
+	}
+	e.post("/v1/metrics", map[string]interface{}{
+		"resourceMetrics": []interface{}{map[string]interface{}{
+			"resource":     e.resource,
+			"scopeMetrics": []interface{}{map[string]interface{}{"scope": otlpScope{Name: "gobench", Version: version}, "metrics": metrics}},
+		}},
+	})
+	e.posts.Wait()
+}
+
+func (e *otlpExporter) post(path string, payload interface{}) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Println("Error encoding OTLP export:", err)
+		return
+	}
+	e.posts.Add(1)
+	go func() {
+		defer e.posts.Done()
+		res, err := http.Post(e.endpoint+path, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Println("Error exporting to OTLP collector:", err)
+			return
+		}
+		defer res.Body.Close()
+		if res.StatusCode < 200 || res.StatusCode >= 300 {
+			reply, _ := ioutil.ReadAll(res.Body)
+			log.Printf("Error exporting to OTLP collector: %s %s", res.Status, bytes.TrimSpace(reply))
+		}
+	}()
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ttacon/chalk"
+)
+
+// colorOutput is whether tables and results are printed with ANSI colors and
+// styles. It is off when stdout isn't a terminal, such as in scripts and CI
+// logs, or when NO_COLOR is set.
+var colorOutput = true
+
+// isTerminal reports whether file is a terminal rather than a file or pipe
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func bold(s string) string {
+	if !colorOutput {
+		return s
+	}
+	return chalk.Bold.TextStyle(s)
+}
+
+func red(s string) string {
+	if !colorOutput {
+		return s
+	}
+	return chalk.Red.Color(s)
+}
+
+// summaryLine is the one line -q prints for a run
+func summaryLine(r *jsonReport) string {
+	return fmt.Sprintf("requests=%d success=%d errors=%d rps=%.1f p50=%.2fms p90=%.2fms p99=%.2fms max=%.2fms duration=%.2fs",
+		r.Results.Requests, r.Results.Success, r.Results.NetworkFailed+r.Results.BadFailed, r.Results.Rate,
+		r.Latency.Percentiles["50"], r.Latency.Percentiles["90"], r.Latency.Percentiles["99"], r.Latency.Max,
+		r.Duration)
+}
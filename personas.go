@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// persona is a kind of client, such as a browser or a bot, that some share of
+// the clients behave as
+type persona struct {
+	name      string
+	share     float64
+	keepAlive bool
+	think     time.Duration
+	// rate is the requests per second each client of the persona sends, or 0
+	// for as fast as it can
+	rate float64
+
+	configuration *Configuration
+}
+
+// parsePersona parses a -persona flag such as
+// name=browser,share=70,keepalive,think=500ms
+func parsePersona(value string) (*persona, error) {
+	p := &persona{}
+	for _, option := range strings.Split(value, ",") {
+		key, val, _ := strings.Cut(strings.TrimSpace(option), "=")
+		var err error
+		switch key {
+		case "name":
+			p.name = val
+		case "share":
+			p.share, err = strconv.ParseFloat(val, 64)
+		case "keepalive":
+			p.keepAlive = val == "" || val == "true"
+		case "think":
+			p.think, err = time.ParseDuration(val)
+		case "rate":
+			p.rate, err = strconv.ParseFloat(val, 64)
+		default:
+			return nil, fmt.Errorf("unknown persona option %q", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("persona option %s: %w", key, err)
+		}
+	}
+	if p.name == "" || p.share <= 0 || p.think < 0 || p.rate < 0 {
+		return nil, fmt.Errorf("a persona needs a name and a positive share")
+	}
+	return p, nil
+}
+
+// assignPersonas divides count clients between the personas in proportion to
+// their shares and returns the persona of each client
+func assignPersonas(personas []*persona, count int) []*persona {
+	var total float64
+	for _, p := range personas {
+		total += p.share
+	}
+	assigned := make([]*persona, 0, count)
+	var cumulative float64
+	for _, p := range personas {
+		cumulative += p.share
+		for len(assigned) < count && float64(len(assigned)) < cumulative/total*float64(count)-0.5 {
+			assigned = append(assigned, p)
+		}
+	}
+	for len(assigned) < count {
+		assigned = append(assigned, personas[len(personas)-1])
+	}
+	return assigned
+}
+
+// personaConfigurations returns the configuration each of count clients uses,
+// giving the clients of each persona their own transport and pacer. Personas
+// without a rate of their own take their clients' share of the run's rate.
+func personaConfigurations(configuration *Configuration, count int) []*Configuration {
+	assigned := assignPersonas(configuration.personas, count)
+	members := make(map[*persona]int)
+	for _, p := range assigned {
+		members[p]++
+	}
+	for _, p := range configuration.personas {
+		if p.configuration == nil {
+			p.configuration = configuration.withKeepAlive(p.keepAlive)
+			p.configuration.persona = p.name
+			p.configuration.thinkTime = p.think
+		}
+		p.configuration.pacer = nil
+		if p.rate > 0 {
+			rate := p.rate * float64(members[p])
+			p.configuration.pacer = newPacer(func(time.Duration) float64 { return rate })
+		} else if configuration.pacer != nil {
+			runRate := configuration.pacer.rate
+			share := float64(members[p]) / float64(count)
+			p.configuration.pacer = newPacer(func(elapsed time.Duration) float64 { return runRate(elapsed) * share })
+		}
+	}
+	configurations := make([]*Configuration, count)
+	for i, p := range assigned {
+		configurations[i] = p.configuration
+	}
+	return configurations
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// progressInterval is how often the progress line is redrawn
+const progressInterval = 500 * time.Millisecond
+
+// progressWidth is the width of the progress bar of runs of a number of
+// requests
+const progressWidth = 30
+
+// progressLine shows how far a run has got and how long it has left: the
+// share of the requests done for runs of a number of requests, and the time
+// left for runs of a period
+type progressLine struct {
+	total  int64
+	period time.Duration
+	drawn  bool
+}
+
+// newProgressLine returns the progress line of a run of configuration, or nil
+// if the run has no end to measure progress towards
+func newProgressLine(configuration *Configuration) *progressLine {
+	p := &progressLine{period: time.Duration(configuration.period) * time.Second}
+	if configuration.queue != nil {
+		p.total = configuration.queue.total
+	} else if configuration.requests > 0 && vuRate == 0 && configuration.replay == nil {
+		p.total = configuration.requests * int64(clients)
+	}
+	if p.total <= 0 && p.period <= 0 {
+		return nil
+	}
+	return p
+}
+
+// draw redraws the line for done requests after elapsed
+func (p *progressLine) draw(done int64, elapsed time.Duration) {
+	var line string
+	if p.total > 0 {
+		share := float64(done) / float64(p.total)
+		if share > 1 {
+			// Retries are more responses than requests
+			share = 1
+		}
+		filled := int(share * progressWidth)
+		line = fmt.Sprintf("[%s%s] %3.0f%% %d/%d", strings.Repeat("=", filled), strings.Repeat(" ", progressWidth-filled),
+			share*100, done, p.total)
+		if done > 0 && share < 1 {
+			left := time.Duration(float64(elapsed) * (1 - share) / share)
+			line += fmt.Sprintf(" ETA %v", left.Round(time.Second))
+		}
+	}
+	if p.period > 0 {
+		elapsed = elapsed.Truncate(time.Second)
+		left := p.period - elapsed
+		if left < 0 {
+			left = 0
+		}
+		if line != "" {
+			line += ", "
+		}
+		line += fmt.Sprintf("%v of %v, %v left", elapsed, p.period, left)
+	}
+	fmt.Fprintf(os.Stdout, "\r%-80s", line)
+	p.drawn = true
+}
+
+// clear removes the line so that what follows starts on a clean line
+func (p *progressLine) clear() {
+	if p.drawn {
+		fmt.Fprintf(os.Stdout, "\r%80s\r", "")
+		p.drawn = false
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import "sync/atomic"
+
+// workQueue shares out an exact total number of requests between all the
+// clients, which take the URLs in turn
+type workQueue struct {
+	total int64
+	taken int64
+}
+
+// take returns the URL of the next request, or false once all the requests
+// have been taken
+func (q *workQueue) take(urls []string) (string, bool) {
+	n := atomic.AddInt64(&q.taken, 1)
+	if n > q.total {
+		return "", false
+	}
+	return urls[(n-1)%int64(len(urls))], true
+}
+
+// reset puts all the requests back for another run
+func (q *workQueue) reset() {
+	atomic.StoreInt64(&q.taken, 0)
+}
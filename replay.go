@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+type replayEntry struct {
+	offset time.Duration
+	method string
+	url    string
+}
+
+// replaySchedule hands out the requests of a -log-requests file to the
+// clients in the order they were sent, each at the same time into the run as
+// it was originally sent.
+type replaySchedule struct {
+	entries []replayEntry
+	next    int64
+	start   time.Time
+}
+
+func loadReplayLog(fileName string) (*replaySchedule, error) {
+	lines, err := readLines(fileName)
+	if err != nil {
+		return nil, err
+	}
+	schedule := &replaySchedule{start: time.Now()}
+	for i, line := range lines {
+		if line == "" {
+			continue
+		}
+		var entry requestLogEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		schedule.entries = append(schedule.entries, replayEntry{
+			offset: time.Duration(entry.Offset * float64(time.Millisecond)),
+			method: entry.Method,
+			url:    entry.URL,
+		})
+	}
+	if len(schedule.entries) == 0 {
+		return nil, fmt.Errorf("no requests in %s", fileName)
+	}
+	// The log is written as responses arrive, not in the order the requests
+	// were sent
+	sort.SliceStable(schedule.entries, func(i, j int) bool {
+		return schedule.entries[i].offset < schedule.entries[j].offset
+	})
+	return schedule, nil
+}
+
+// reset starts the schedule again from start
+func (s *replaySchedule) reset(start time.Time) {
+	s.start = start
+	atomic.StoreInt64(&s.next, 0)
+}
+
+// wait takes the next request off the schedule and blocks until it is due. It
+// returns false once every request has been handed out or ctx is done.
+func (s *replaySchedule) wait(ctx context.Context) (replayEntry, bool) {
+	i := atomic.AddInt64(&s.next, 1) - 1
+	if i >= int64(len(s.entries)) {
+		return replayEntry{}, false
+	}
+	entry := s.entries[i]
+	if !sleep(ctx, time.Until(s.start.Add(entry.offset))) {
+		return replayEntry{}, false
+	}
+	return entry, true
+}
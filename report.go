@@ -0,0 +1,425 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/glentiki/hdrhistogram"
+	"github.com/olekukonko/tablewriter"
+)
+
+const (
+	// reportSchema names the layout of -report-json files
+	reportSchema = "gobench-report"
+	// reportVersion goes up whenever a field of the report is renamed or
+	// removed or changes meaning. Adding a field keeps the version, so readers
+	// should ignore fields they don't know.
+	//
+	// Version 2 has latencies as fractions of a millisecond, where version 1
+	// had whole milliseconds.
+	reportVersion = 2
+)
+
+// version is the gobench release, set when building a release with
+// -ldflags "-X main.version=..."
+var version = "dev"
+
+// reportPercentiles are the latency percentiles included in the report
+var reportPercentiles = []float64{50, 75, 90, 95, 99, 99.9, 99.99}
+
+type jsonReport struct {
+	Schema        string        `json:"schema"`
+	SchemaVersion int           `json:"schema_version"`
+	ToolVersion   string        `json:"tool_version"`
+	Started       time.Time     `json:"started"`
+	Finished      time.Time     `json:"finished"`
+	Duration      float64       `json:"duration_seconds"`
+	Interrupted   bool          `json:"interrupted"`
+	Aborted       string        `json:"aborted,omitempty"`
+	Config        reportConfig  `json:"config"`
+	Results       reportResults `json:"results"`
+	Latency       reportLatency `json:"latency"`
+
+	// StatusCodes counts the responses with each status code
+	StatusCodes map[string]int64 `json:"status_codes"`
+
+	// NetworkErrors counts the requests that failed with each kind of
+	// network failure
+	NetworkErrors map[string]int64 `json:"network_errors,omitempty"`
+
+	// CorrectedLatency is measured from when paced requests were due to be
+	// sent, rather than when they were
+	CorrectedLatency *reportLatency `json:"corrected_latency,omitempty"`
+
+	// CompleteLatency is the latency of the successful responses up to the
+	// end of their body, where Latency is up to their headers
+	CompleteLatency reportLatency `json:"complete_latency"`
+
+	// TLSHandshakeTime is how long the TLS handshakes took
+	TLSHandshakeTime *reportLatency `json:"tls_handshake_time,omitempty"`
+
+	// Non2xxLatency is the latency of the responses that weren't 2xx and
+	// FailedLatency of the requests that got no response, and SlowLatency of
+	// the 2xx responses over -fail-over
+	Non2xxLatency *reportLatency `json:"non_2xx_latency,omitempty"`
+	FailedLatency *reportLatency `json:"failed_latency,omitempty"`
+	SlowLatency   *reportLatency `json:"too_slow_latency,omitempty"`
+
+	// ResponseSizes is the distribution of response sizes, in bytes, of all
+	// responses and of each status class
+	ResponseSizes map[string]reportSizes `json:"response_sizes"`
+
+	// URLs breaks the results down by URL when there was more than one
+	URLs map[string]reportURL `json:"urls,omitempty"`
+
+	// Slowest are the -slowest requests of the run, slowest first
+	Slowest []reportSlowRequest `json:"slowest,omitempty"`
+}
+
+type reportSizes struct {
+	Count     int64            `json:"count"`
+	Min       int64            `json:"min"`
+	Mean      float64          `json:"mean"`
+	P50       int64            `json:"p50"`
+	P99       int64            `json:"p99"`
+	Max       int64            `json:"max"`
+	Total     int64            `json:"total"`
+	Histogram *reportHistogram `json:"histogram,omitempty"`
+}
+
+type reportURL struct {
+	Requests int64         `json:"requests"`
+	Success  int64         `json:"success"`
+	Failed   int64         `json:"failed"`
+	Latency  reportLatency `json:"latency"`
+}
+
+type reportConfig struct {
+	Method string                `json:"method"`
+	URLs   []string              `json:"urls"`
+	Flags  map[string]reportFlag `json:"flags"`
+}
+
+type reportFlag struct {
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+type reportResults struct {
+	Requests          int64   `json:"requests"`
+	Success           int64   `json:"success"`
+	NetworkFailed     int64   `json:"network_failed"`
+	BadFailed         int64   `json:"bad_failed"`
+	TooSlow           int64   `json:"too_slow"`
+	Retries           int64   `json:"retries"`
+	Replayed          int64   `json:"replayed"`
+	RateLimited       int64   `json:"rate_limited"`
+	RateLimitDelay    float64 `json:"rate_limit_delay_seconds"`
+	BreakerOpened     int64   `json:"breaker_opened"`
+	BreakerHalfOpened int64   `json:"breaker_half_opened"`
+	BreakerClosed     int64   `json:"breaker_closed"`
+	Rate              float64 `json:"success_per_second"`
+	ReadThroughput    float64 `json:"read_bytes_per_second"`
+	WriteThroughput   float64 `json:"write_bytes_per_second"`
+	IPv4Connections   int64   `json:"ipv4_connections"`
+	IPv6Connections   int64   `json:"ipv6_connections"`
+	FullHandshakes    int64   `json:"tls_full_handshakes"`
+	ResumedHandshakes int64   `json:"tls_resumed_handshakes"`
+	GzipResponses     int64   `json:"gzip_responses"`
+	CompressedBytes   int64   `json:"compressed_body_bytes"`
+	DecompressedBytes int64   `json:"decompressed_body_bytes"`
+}
+
+type reportLatency struct {
+	Unit        string             `json:"unit"`
+	Count       int64              `json:"count"`
+	Min         float64            `json:"min"`
+	Mean        float64            `json:"mean"`
+	StdDev      float64            `json:"stddev"`
+	Max         float64            `json:"max"`
+	Percentiles map[string]float64 `json:"percentiles"`
+	// Histogram is in microseconds
+	Histogram *reportHistogram `json:"histogram,omitempty"`
+}
+
+// reportHistogram is a histogram in a report, kept so that gobench merge can
+// combine the reports of several runs. Buckets are the index and count of
+// every bucket that isn't empty.
+type reportHistogram struct {
+	Lowest             int64      `json:"lowest"`
+	Highest            int64      `json:"highest"`
+	SignificantFigures int64      `json:"significant_figures"`
+	Buckets            [][2]int64 `json:"buckets"`
+}
+
+func newReportHistogram(h *hdrhistogram.Histogram) *reportHistogram {
+	snapshot := h.Export()
+	r := &reportHistogram{
+		Lowest:             snapshot.LowestTrackableValue,
+		Highest:            snapshot.HighestTrackableValue,
+		SignificantFigures: snapshot.SignificantFigures,
+		Buckets:            [][2]int64{},
+	}
+	for i, count := range snapshot.Counts {
+		if count != 0 {
+			r.Buckets = append(r.Buckets, [2]int64{int64(i), count})
+		}
+	}
+	return r
+}
+
+// histogram rebuilds the histogram the report was made from
+func (r *reportHistogram) histogram() (*hdrhistogram.Histogram, error) {
+	snapshot := hdrhistogram.New(r.Lowest, r.Highest, int(r.SignificantFigures)).Export()
+	for _, bucket := range r.Buckets {
+		if bucket[0] < 0 || bucket[0] >= int64(len(snapshot.Counts)) {
+			return nil, fmt.Errorf("histogram bucket %d is out of range", bucket[0])
+		}
+		snapshot.Counts[bucket[0]] = bucket[1]
+	}
+	return hdrhistogram.Import(snapshot), nil
+}
+
+// newReport gathers the configuration and results of a run into a report
+func newReport(configuration *Configuration, stats *runStats) *jsonReport {
+	seconds := stats.elapsed.Seconds()
+	if seconds <= 0 {
+		seconds = 1
+	}
+	sum := total(stats.results)
+
+	r := &jsonReport{
+		Schema:        reportSchema,
+		SchemaVersion: reportVersion,
+		ToolVersion:   version,
+		Started:       stats.start,
+		Finished:      stats.start.Add(stats.elapsed),
+		Duration:      stats.elapsed.Seconds(),
+		Interrupted:   stats.interrupted,
+		Aborted:       stats.aborted,
+		Config: reportConfig{
+			Method: configuration.method,
+			URLs:   configuration.urls,
+			Flags:  make(map[string]reportFlag),
+		},
+		Results: reportResults{
+			Requests:          sum.requests,
+			Success:           sum.success,
+			NetworkFailed:     sum.networkFailed,
+			BadFailed:         sum.badFailed,
+			TooSlow:           sum.tooSlow,
+			Retries:           sum.retries,
+			Replayed:          sum.replayed,
+			RateLimited:       sum.rateLimited,
+			RateLimitDelay:    sum.rateLimitDelay.Seconds(),
+			BreakerOpened:     sum.breakerOpened,
+			BreakerHalfOpened: sum.breakerHalfOpened,
+			BreakerClosed:     sum.breakerClosed,
+			Rate:              float64(sum.success) / seconds,
+			ReadThroughput:    float64(atomic.LoadInt64(&readThroughput)) / seconds,
+			WriteThroughput:   float64(atomic.LoadInt64(&writeThroughput)) / seconds,
+			IPv4Connections:   atomic.LoadInt64(&ipv4Connections),
+			IPv6Connections:   atomic.LoadInt64(&ipv6Connections),
+			FullHandshakes:    atomic.LoadInt64(&fullHandshakes),
+			ResumedHandshakes: atomic.LoadInt64(&resumedHandshakes),
+			GzipResponses:     sum.gzipResponses,
+			CompressedBytes:   sum.compressedBytes,
+			DecompressedBytes: sum.decompressedBytes,
+		},
+		Latency:     newReportLatency(stats.collector.latencies),
+		StatusCodes: make(map[string]int64),
+	}
+	for status, count := range stats.collector.statuses {
+		r.StatusCodes[strconv.Itoa(status)] = count
+	}
+	r.ResponseSizes = stats.collector.sizes.report()
+	if stats.collector.slowest != nil {
+		r.Slowest = stats.collector.slowest.report()
+	}
+	if len(stats.collector.networkErrors) > 0 {
+		r.NetworkErrors = stats.collector.networkErrors
+	}
+	if corrected := stats.collector.corrected; corrected != nil {
+		latency := newReportLatency(corrected)
+		r.CorrectedLatency = &latency
+	}
+	r.TLSHandshakeTime = tlsHandshakeReport()
+	r.CompleteLatency = newReportLatency(stats.collector.fullLatencies)
+	if non2xx := stats.collector.non2xxLatencies; non2xx != nil {
+		latency := newReportLatency(non2xx)
+		r.Non2xxLatency = &latency
+	}
+	if failed := stats.collector.failedLatencies; failed != nil {
+		latency := newReportLatency(failed)
+		r.FailedLatency = &latency
+	}
+	if slow := stats.collector.slowLatencies; slow != nil {
+		latency := newReportLatency(slow)
+		r.SlowLatency = &latency
+	}
+	if urls := stats.collector.urls; urls != nil {
+		r.URLs = make(map[string]reportURL)
+		for url, result := range urls.results {
+			r.URLs[url] = reportURL{
+				Requests: result.requests,
+				Success:  result.success,
+				Failed:   result.failed,
+				Latency:  newReportLatency(result.latencies),
+			}
+		}
+	}
+
+	given := givenFlags()
+	flag.VisitAll(func(f *flag.Flag) {
+		r.Config.Flags[f.Name] = reportFlag{Value: flagValue(f), Source: flagSource(f, given)}
+	})
+	return r
+}
+
+func newReportLatency(latencies *hdrhistogram.Histogram) reportLatency {
+	latency := reportLatency{
+		Unit:        "ms",
+		Count:       latencies.TotalCount(),
+		Min:         latencyMilliseconds(latencies.Min()),
+		Mean:        latencies.Mean() / 1000,
+		StdDev:      latencies.StdDev() / 1000,
+		Max:         latencyMilliseconds(latencies.Max()),
+		Percentiles: make(map[string]float64),
+		Histogram:   newReportHistogram(latencies),
+	}
+	for _, percentile := range reportPercentiles {
+		latency.Percentiles[strconv.FormatFloat(percentile, 'f', -1, 64)] = latencyMilliseconds(latencies.ValueAtPercentile(percentile))
+	}
+	return latency
+}
+
+// writeReport writes the report of a run as JSON to fileName
+func writeReport(fileName string, configuration *Configuration, stats *runStats) error {
+	data, err := encodeReport(configuration, stats)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fileName, data, 0644)
+}
+
+// encodeReport returns the report of a run as indented JSON
+func encodeReport(configuration *Configuration, stats *runStats) ([]byte, error) {
+	return marshalReport(newReport(configuration, stats))
+}
+
+// marshalReport returns r as indented JSON
+func marshalReport(r *jsonReport) ([]byte, error) {
+	var data bytes.Buffer
+	encoder := json.NewEncoder(&data)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(r); err != nil {
+		return nil, err
+	}
+	return data.Bytes(), nil
+}
+
+// reportCommand prints a report written by -report-json
+func reportCommand(args []string) {
+	flags := flag.NewFlagSet("report", flag.ExitOnError)
+	showConfig := flags.Bool("config", false, "Also print the flags the run was given")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fmt.Println("Usage: gobench report [-config] FILE")
+		flags.PrintDefaults()
+		os.Exit(1)
+	}
+
+	r, err := readReport(flags.Arg(0))
+	if err != nil {
+		log.Fatalf("Error reading report %s: %s", flags.Arg(0), err)
+	}
+
+	fmt.Printf("Run of gobench %s started %s\n", r.ToolVersion, r.Started.Format(time.RFC1123))
+	if *showConfig {
+		names := make([]string, 0, len(r.Config.Flags))
+		for name, f := range r.Config.Flags {
+			if f.Source != "default" {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("  -%-20s %-40q (%s)\n", name, r.Config.Flags[name].Value, r.Config.Flags[name].Source)
+		}
+	}
+	fmt.Println()
+	fmt.Printf("Requests:                       %10d hits\n", r.Results.Requests)
+	fmt.Printf("Successful requests:            %10d hits\n", r.Results.Success)
+	fmt.Printf("Network failed:                 %10d hits\n", r.Results.NetworkFailed)
+	fmt.Printf("Bad requests failed (!2xx):     %10d hits\n", r.Results.BadFailed)
+	fmt.Printf("Successful requests rate:       %10.0f hits/sec\n", r.Results.Rate)
+	fmt.Printf("Read throughput:                %10.0f bytes/sec\n", r.Results.ReadThroughput)
+	fmt.Printf("Write throughput:               %10.0f bytes/sec\n", r.Results.WriteThroughput)
+	fmt.Printf("Test time:                      %10.2f sec\n", r.Duration)
+	if r.Interrupted {
+		fmt.Println("The run was interrupted")
+	}
+
+	percentiles := make([]string, 0, len(r.Latency.Percentiles))
+	for percentile := range r.Latency.Percentiles {
+		percentiles = append(percentiles, percentile)
+	}
+	sort.Slice(percentiles, func(i, j int) bool {
+		a, _ := strconv.ParseFloat(percentiles[i], 64)
+		b, _ := strconv.ParseFloat(percentiles[j], 64)
+		return a < b
+	})
+	fmt.Println()
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetRowSeparator("-")
+	header := []string{"Stat"}
+	row := []string{"Latency"}
+	for _, percentile := range percentiles {
+		header = append(header, percentile+"%")
+		row = append(row, fmt.Sprintf("%.2f %s", r.Latency.Percentiles[percentile], r.Latency.Unit))
+	}
+	header = append(header, "Avg", "Stdev", "Min", "Max")
+	row = append(row,
+		fmt.Sprintf("%.2f %s", r.Latency.Mean, r.Latency.Unit),
+		fmt.Sprintf("%.2f %s", r.Latency.StdDev, r.Latency.Unit),
+		fmt.Sprintf("%.2f %s", r.Latency.Min, r.Latency.Unit),
+		fmt.Sprintf("%.2f %s", r.Latency.Max, r.Latency.Unit))
+	table.SetHeader(header)
+	table.Append(row)
+	table.Render()
+}
+
+// readReport reads a report written by -report-json, refusing ones written to
+// a later version of the schema
+func readReport(fileName string) (*jsonReport, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	var r jsonReport
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, err
+	}
+	if r.Schema != reportSchema {
+		return nil, fmt.Errorf("not a gobench report")
+	}
+	if r.SchemaVersion > reportVersion {
+		return nil, fmt.Errorf("report schema version %d is newer than this gobench understands (%d)", r.SchemaVersion, reportVersion)
+	}
+	// Version 1 latencies were whole milliseconds, which read as the same
+	// values in version 2, so they need no converting
+	if r.SchemaVersion < 1 {
+		return nil, fmt.Errorf("report schema version %d is not one this gobench understands", r.SchemaVersion)
+	}
+	return &r, nil
+}
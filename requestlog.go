@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"strings"
+	"time"
+)
+
+// requestLogEntry is one line of the -log-requests file
+type requestLogEntry struct {
+	Sent    time.Time `json:"sent"`
+	Offset  float64   `json:"offset_ms"`
+	Method  string    `json:"method"`
+	URL     string    `json:"url"`
+	Status  int       `json:"status"`
+	Latency float64   `json:"latency_ms"`
+	Size    int       `json:"size"`
+	Error   string    `json:"error,omitempty"`
+
+	Trailers map[string]string `json:"trailers,omitempty"`
+}
+
+// requestLog writes every request of a run to a file as newline delimited JSON
+type requestLog struct {
+	file    *os.File
+	writer  *bufio.Writer
+	encoder *json.Encoder
+	start   time.Time
+}
+
+func newRequestLog(fileName string, start time.Time) (*requestLog, error) {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return nil, err
+	}
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	encoder.SetEscapeHTML(false)
+	return &requestLog{file: file, writer: writer, encoder: encoder, start: start}, nil
+}
+
+func (l *requestLog) record(res *resp) error {
+	var trailers map[string]string
+	for _, trailer := range res.trailers {
+		if trailers == nil {
+			trailers = make(map[string]string)
+		}
+		name, value, _ := strings.Cut(trailer, ": ")
+		if trailers[name] != "" {
+			value = trailers[name] + ", " + value
+		}
+		trailers[name] = value
+	}
+	return l.encoder.Encode(requestLogEntry{
+		Sent:    res.sent,
+		Offset:  float64(res.sent.Sub(l.start)) / float64(time.Millisecond),
+		Method:  res.method,
+		URL:     res.url,
+		Status:  res.status,
+		Latency: latencyMilliseconds(res.latency),
+		Size:    res.size,
+		Error:   res.err,
+
+		Trailers: trailers,
+	})
+}
+
+func (l *requestLog) close() error {
+	if err := l.writer.Flush(); err != nil {
+		l.file.Close()
+		return err
+	}
+	return l.file.Close()
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// showCertOnce makes -show-cert print only the first connection's certificates
+var showCertOnce sync.Once
+
+// printConnection prints what a TLS handshake negotiated and the certificate
+// chain the server presented
+func printConnection(state tls.ConnectionState) {
+	fmt.Println("TLS version:   ", tls.VersionName(state.Version))
+	fmt.Println("Cipher suite:  ", tls.CipherSuiteName(state.CipherSuite))
+	if state.CurveID != 0 {
+		fmt.Println("Key exchange:  ", curveName(state.CurveID))
+	}
+	if state.NegotiatedProtocol != "" {
+		fmt.Println("ALPN protocol: ", state.NegotiatedProtocol)
+	}
+	fmt.Println("Server name:   ", state.ServerName)
+	printCertificates(state.PeerCertificates)
+}
+
+func printCertificates(certs []*x509.Certificate) {
+	now := time.Now()
+	for i, cert := range certs {
+		fmt.Printf("Certificate %d:\n", i)
+		fmt.Println("  Subject:     ", cert.Subject)
+		fmt.Println("  Issuer:      ", cert.Issuer)
+		var names []string
+		names = append(names, cert.DNSNames...)
+		for _, ip := range cert.IPAddresses {
+			names = append(names, ip.String())
+		}
+		if len(names) > 0 {
+			fmt.Println("  SANs:        ", strings.Join(names, ", "))
+		}
+		fmt.Println("  Serial:      ", cert.SerialNumber)
+		fmt.Println("  Not before:  ", cert.NotBefore.Format(time.RFC3339))
+		expiry := fmt.Sprintf("%s (in %d days)", cert.NotAfter.Format(time.RFC3339), int(cert.NotAfter.Sub(now).Hours()/24))
+		if now.After(cert.NotAfter) {
+			expiry = red(cert.NotAfter.Format(time.RFC3339) + " (expired)")
+		}
+		fmt.Println("  Not after:   ", expiry)
+	}
+	fmt.Println("")
+}
+
+// showCertificates prints the first connection's handshake for -show-cert
+func showCertificates(state tls.ConnectionState) {
+	showCertOnce.Do(func() { printConnection(state) })
+}
+
+// showRejectedCertificates prints the certificates of the first connection
+// whose chain failed verification for -show-cert, so that it can be seen why
+func showRejectedCertificates(err *tls.CertificateVerificationError) {
+	showCertOnce.Do(func() {
+		fmt.Println("Certificate verification failed:", err.Err)
+		printCertificates(err.UnverifiedCertificates)
+	})
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/glentiki/hdrhistogram"
+	"github.com/olekukonko/tablewriter"
+)
+
+// maxResponseSize is the largest response size the histograms can tell apart
+// from each other. Bigger responses are counted as this size.
+const maxResponseSize = 1 << 30
+
+// responseSizes keeps a histogram of the sizes of all responses and of the
+// responses of each status class, so that truncated responses or error pages
+// served in place of the real thing stand out
+type responseSizes struct {
+	all     *hdrhistogram.Histogram
+	classes [6]*hdrhistogram.Histogram
+	bytes   [6]int64
+}
+
+func newResponseSizes() *responseSizes {
+	return &responseSizes{all: hdrhistogram.New(1, maxResponseSize, 3)}
+}
+
+func (s *responseSizes) record(res *resp) {
+	class := res.status / 100
+	if class < 1 || class > 5 {
+		return
+	}
+	size := int64(res.size)
+	if size > maxResponseSize {
+		size = maxResponseSize
+	}
+	if s.classes[class] == nil {
+		s.classes[class] = hdrhistogram.New(1, maxResponseSize, 3)
+	}
+	s.classes[class].RecordValue(size)
+	s.all.RecordValue(size)
+	s.bytes[class] += int64(res.size)
+	s.bytes[0] += int64(res.size)
+}
+
+// newReportSizes returns the sizes in h, of responses that came to bytes in
+// all, for the JSON report
+func newReportSizes(h *hdrhistogram.Histogram, bytes int64) reportSizes {
+	return reportSizes{
+		Count:     h.TotalCount(),
+		Min:       h.Min(),
+		Mean:      h.Mean(),
+		P50:       h.ValueAtPercentile(50),
+		P99:       h.ValueAtPercentile(99),
+		Max:       h.Max(),
+		Total:     bytes,
+		Histogram: newReportHistogram(h),
+	}
+}
+
+// report returns the sizes for the JSON report, keyed by "all" and the
+// status classes, such as "2xx"
+func (s *responseSizes) report() map[string]reportSizes {
+	r := map[string]reportSizes{"all": newReportSizes(s.all, s.bytes[0])}
+	for class := 1; class <= 5; class++ {
+		if s.classes[class] != nil {
+			r[fmt.Sprintf("%dxx", class)] = newReportSizes(s.classes[class], s.bytes[class])
+		}
+	}
+	return r
+}
+
+func (s *responseSizes) print() {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetRowSeparator("-")
+	table.SetHeader([]string{"Response size", "Responses", "Min", "50%", "99%", "Avg", "Max", "Total"})
+	row := func(name string, sizes *hdrhistogram.Histogram, bytes int64) []string {
+		return []string{
+			bold(name),
+			fmt.Sprintf("%d", sizes.TotalCount()),
+			fmt.Sprintf("%d bytes", sizes.Min()),
+			fmt.Sprintf("%d bytes", sizes.ValueAtPercentile(50)),
+			fmt.Sprintf("%d bytes", sizes.ValueAtPercentile(99)),
+			fmt.Sprintf("%.0f bytes", sizes.Mean()),
+			fmt.Sprintf("%d bytes", sizes.Max()),
+			fmt.Sprintf("%d bytes", bytes),
+		}
+	}
+	for class := 1; class <= 5; class++ {
+		if s.classes[class] != nil {
+			table.Append(row(fmt.Sprintf("%dxx", class), s.classes[class], s.bytes[class]))
+		}
+	}
+	table.Append(row("All", s.all, s.bytes[0]))
+	table.Render()
+	fmt.Println("")
+}
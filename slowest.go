@@ -0,0 +1,201 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http/httptrace"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// requestTiming is where the time of a request went. The trace fills it in
+// from the transport's goroutines, including a dial that may finish after the
+// request went out on another connection, so it is read with snapshot.
+type requestTiming struct {
+	sync.Mutex
+	dns     time.Duration
+	connect time.Duration
+	tls     time.Duration
+	ttfb    time.Duration
+	reused  bool
+}
+
+// traceTiming returns ctx with a trace that fills in the timing of the request
+// sent with it, measured from start, and with -tls-timing records the time of
+// any TLS handshake it makes
+func traceTiming(ctx context.Context, start time.Time) (context.Context, *requestTiming) {
+	timing := &requestTiming{}
+	var dnsStart, connectStart, tlsStart time.Time
+	set := func(field *time.Duration, d time.Duration) {
+		timing.Lock()
+		*field = d
+		timing.Unlock()
+	}
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone:  func(httptrace.DNSDoneInfo) { set(&timing.dns, time.Since(dnsStart)) },
+		ConnectStart: func(string, string) {
+			if connectStart.IsZero() {
+				connectStart = time.Now()
+			}
+		},
+		ConnectDone:       func(string, string, error) { set(&timing.connect, time.Since(connectStart)) },
+		TLSHandshakeStart: func() { tlsStart = time.Now() },
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			d := time.Since(tlsStart)
+			set(&timing.tls, d)
+			if err == nil && tlsTiming {
+				recordTLSHandshake(d)
+			}
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			timing.Lock()
+			timing.reused = info.Reused
+			timing.Unlock()
+		},
+		GotFirstResponseByte: func() { set(&timing.ttfb, time.Since(start)) },
+	}
+	return httptrace.WithClientTrace(ctx, trace), timing
+}
+
+// snapshot returns a copy of the timing as it is now, or nil without one
+func (t *requestTiming) snapshot() *requestTiming {
+	if t == nil {
+		return nil
+	}
+	t.Lock()
+	defer t.Unlock()
+	return &requestTiming{dns: t.dns, connect: t.connect, tls: t.tls, ttfb: t.ttfb, reused: t.reused}
+}
+
+// slowRequests keeps the slowest -slowest requests of a run, in a heap with
+// the fastest of them at the top to be pushed out by a slower one
+type slowRequests struct {
+	size  int
+	start time.Time
+	resps []resp
+}
+
+func newSlowRequests(size int, start time.Time) *slowRequests {
+	return &slowRequests{size: size, start: start}
+}
+
+func (s *slowRequests) Len() int           { return len(s.resps) }
+func (s *slowRequests) Less(i, j int) bool { return s.duration(i) < s.duration(j) }
+func (s *slowRequests) Swap(i, j int)      { s.resps[i], s.resps[j] = s.resps[j], s.resps[i] }
+func (s *slowRequests) Push(x interface{}) { s.resps = append(s.resps, x.(resp)) }
+func (s *slowRequests) Pop() interface{} {
+	last := s.resps[len(s.resps)-1]
+	s.resps = s.resps[:len(s.resps)-1]
+	return last
+}
+
+func (s *slowRequests) duration(i int) time.Duration {
+	return s.resps[i].received.Sub(s.resps[i].sent)
+}
+
+func (s *slowRequests) record(res *resp) {
+	if len(s.resps) < s.size {
+		heap.Push(s, *res)
+	} else if res.received.Sub(res.sent) > s.duration(0) {
+		s.resps[0] = *res
+		heap.Fix(s, 0)
+	}
+}
+
+// sorted returns the slow requests, slowest first
+func (s *slowRequests) sorted() []resp {
+	resps := append([]resp(nil), s.resps...)
+	sort.Slice(resps, func(i, j int) bool {
+		return resps[i].received.Sub(resps[i].sent) > resps[j].received.Sub(resps[j].sent)
+	})
+	return resps
+}
+
+// reportSlowRequest is one of the -slowest requests in the JSON report, with
+// its times in milliseconds
+type reportSlowRequest struct {
+	Sent      time.Time `json:"sent"`
+	Offset    float64   `json:"offset"`
+	Method    string    `json:"method"`
+	URL       string    `json:"url"`
+	Status    int       `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	Latency   float64   `json:"latency"`
+	DNS       float64   `json:"dns"`
+	Connect   float64   `json:"connect"`
+	TLS       float64   `json:"tls"`
+	FirstByte float64   `json:"first_byte"`
+	Reused    bool      `json:"reused_connection"`
+}
+
+func (s *slowRequests) report() []reportSlowRequest {
+	milliseconds := func(d time.Duration) float64 {
+		return float64(d) / float64(time.Millisecond)
+	}
+	var slow []reportSlowRequest
+	for _, res := range s.sorted() {
+		r := reportSlowRequest{
+			Sent:    res.sent,
+			Offset:  milliseconds(res.sent.Sub(s.start)),
+			Method:  res.method,
+			URL:     res.url,
+			Status:  res.status,
+			Error:   res.err,
+			Latency: milliseconds(res.received.Sub(res.sent)),
+		}
+		if t := res.timing; t != nil {
+			r.DNS = milliseconds(t.dns)
+			r.Connect = milliseconds(t.connect)
+			r.TLS = milliseconds(t.tls)
+			r.FirstByte = milliseconds(t.ttfb)
+			r.Reused = t.reused
+		}
+		slow = append(slow, r)
+	}
+	return slow
+}
+
+// print lists the slow requests, slowest first
+func (s *slowRequests) print() {
+	resps := s.sorted()
+	milliseconds := func(d time.Duration) string {
+		if d == 0 {
+			return "-"
+		}
+		return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 2, 64) + " ms"
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetRowSeparator("-")
+	table.SetHeader([]string{"Slowest", "Latency", "Status", "URL", "Sent at", "DNS", "Connect", "TLS", "First byte"})
+	for i, res := range resps {
+		status := strconv.Itoa(res.status)
+		if res.status == 0 {
+			status = res.errorKind
+		}
+		row := []string{
+			bold(strconv.Itoa(i + 1)),
+			milliseconds(res.received.Sub(res.sent)),
+			status,
+			res.method + " " + res.url,
+			fmt.Sprintf("%v (%s)", res.sent.Sub(s.start).Round(time.Millisecond), res.sent.Format("15:04:05.000")),
+		}
+		if t := res.timing; t != nil && t.reused {
+			row = append(row, "-", "reused", "-", milliseconds(t.ttfb))
+		} else if t != nil {
+			row = append(row, milliseconds(t.dns), milliseconds(t.connect), milliseconds(t.tls), milliseconds(t.ttfb))
+		} else {
+			row = append(row, "", "", "", "")
+		}
+		table.Append(row)
+	}
+	table.Render()
+	fmt.Println("")
+}
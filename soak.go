@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/glentiki/hdrhistogram"
+)
+
+// intervalSummary gathers the statistics of the responses since the last
+// interim summary of a long run, so that a change in behaviour isn't hidden
+// by everything that came before it
+type intervalSummary struct {
+	start     time.Time
+	requests  int64
+	success   int64
+	failed    int64
+	latencies *hdrhistogram.Histogram
+}
+
+func newIntervalSummary(start time.Time) *intervalSummary {
+	return &intervalSummary{start: start, latencies: newLatencyHistogram(3)}
+}
+
+func (s *intervalSummary) record(res *resp) {
+	s.requests++
+	if res.status >= 200 && res.status < 300 && !res.tooSlow {
+		s.success++
+		recordLatency(s.latencies, res.latency)
+	} else {
+		s.failed++
+	}
+}
+
+// print prints the summary of the interval to now, alongside the run so far
+// unless it is the compact line of -interval, and starts the next interval
+func (s *intervalSummary) print(c *collector, now time.Time) {
+	interval := now.Sub(s.start)
+	if statsInterval > 0 {
+		fmt.Printf("[%v] %d requests, %d errors, %.0f hits/sec, p50 %.2f ms, p99 %.2f ms\n",
+			now.Sub(c.start).Round(time.Second), s.requests, s.failed, float64(s.success)/interval.Seconds(),
+			latencyMilliseconds(s.latencies.ValueAtPercentile(50)), latencyMilliseconds(s.latencies.ValueAtPercentile(99)))
+		s.reset(now)
+		return
+	}
+	fmt.Printf("[%v] last %v: %d requests, %d failed, %.0f hits/sec, p50 %.2f ms, p99 %.2f ms, max %.2f ms | run: %d requests, p99 %.2f ms",
+		now.Sub(c.start).Round(time.Second), interval.Round(time.Second), s.requests, s.failed,
+		float64(s.success)/interval.Seconds(), latencyMilliseconds(s.latencies.ValueAtPercentile(50)),
+		latencyMilliseconds(s.latencies.ValueAtPercentile(99)), latencyMilliseconds(s.latencies.Max()),
+		c.responses, latencyMilliseconds(c.latencies.ValueAtPercentile(99)))
+	if c.drift != nil && len(c.drift.p99s) >= 2 {
+		growth, _, _, _ := c.drift.trend()
+		fmt.Printf(", p99 trend %+.0f%%", growth)
+	}
+	fmt.Println()
+	s.reset(now)
+}
+
+// reset starts a new interval at now
+func (s *intervalSummary) reset(now time.Time) {
+	s.start = now
+	s.requests = 0
+	s.success = 0
+	s.failed = 0
+	s.latencies.Reset()
+}
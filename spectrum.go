@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/glentiki/hdrhistogram"
+)
+
+// spectrumTicksPerHalfDistance is how many percentiles the spectrum lists
+// between 0 and 50%, between 50% and 75%, and so on, as HdrHistogram does
+const spectrumTicksPerHalfDistance = 5
+
+// writeSpectrum writes the latency percentile spectrum in the text format of
+// HdrHistogram's outputPercentileDistribution, which wrk2 prints and hdrplot
+// and the HdrHistogram plotter read. Values are in milliseconds.
+func writeSpectrum(out io.Writer, latencies *hdrhistogram.Histogram) error {
+	w := bufio.NewWriter(out)
+	total := latencies.TotalCount()
+	fmt.Fprintf(w, "%12s %14s %10s %14s\n\n", "Value", "Percentile", "TotalCount", "1/(1-Percentile)")
+	if total > 0 {
+		for percentile := 0.0; ; {
+			value := latencies.ValueAtQuantile(percentile)
+			count := int64(math.Ceil(percentile / 100 * float64(total)))
+			if count < 1 {
+				count = 1
+			}
+			if percentile >= 100 {
+				fmt.Fprintf(w, "%12.3f %2.12f %10d\n", latencyMilliseconds(value), 1.0, total)
+				break
+			}
+			fmt.Fprintf(w, "%12.3f %2.12f %10d %14.2f\n", latencyMilliseconds(value), percentile/100, count, 100/(100-percentile))
+
+			// Halve the distance to 100% every spectrumTicksPerHalfDistance
+			// steps, until the steps are finer than one request
+			halfDistance := math.Pow(2, math.Floor(math.Log2(100/(100-percentile)))+1)
+			percentile += 100 / (halfDistance * spectrumTicksPerHalfDistance)
+			if percentile >= 100 || (100-percentile)/100*float64(total) < 1 {
+				percentile = 100
+			}
+		}
+	}
+	fmt.Fprintf(w, "#[Mean    = %12.3f, StdDeviation   = %12.3f]\n", latencies.Mean()/1000, latencies.StdDev()/1000)
+	fmt.Fprintf(w, "#[Max     = %12.3f, Total count    = %12d]\n", latencyMilliseconds(latencies.Max()), total)
+	return w.Flush()
+}
+
+// saveSpectrum writes the percentile spectrum to fileName, or to stdout if it
+// is "-"
+func saveSpectrum(fileName string, latencies *hdrhistogram.Histogram) error {
+	if fileName == "-" {
+		fmt.Println("")
+		return writeSpectrum(os.Stdout, latencies)
+	}
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	if err := writeSpectrum(file, latencies); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// stage is one phase of a -stages load profile, offering rate requests per
+// second across all clients for duration
+type stage struct {
+	duration time.Duration
+	rate     float64
+}
+
+// parseStages parses a -stages flag such as 1m:100rps,2m:500rps,1m:0
+func parseStages(spec string) ([]stage, error) {
+	var stages []stage
+	for _, item := range strings.Split(spec, ",") {
+		duration, rate, ok := strings.Cut(strings.TrimSpace(item), ":")
+		if !ok {
+			return nil, fmt.Errorf("%q is not DURATION:RATE", item)
+		}
+		var s stage
+		var err error
+		if s.duration, err = time.ParseDuration(duration); err != nil || s.duration <= 0 {
+			return nil, fmt.Errorf("%q needs a positive duration", item)
+		}
+		if s.rate, err = strconv.ParseFloat(strings.TrimSuffix(rate, "rps"), 64); err != nil || s.rate < 0 {
+			return nil, fmt.Errorf("%q needs a rate of zero or more", item)
+		}
+		stages = append(stages, s)
+	}
+	return stages, nil
+}
+
+// stagesLength returns how long all the stages take
+func stagesLength(stages []stage) time.Duration {
+	var length time.Duration
+	for _, s := range stages {
+		length += s.duration
+	}
+	return length
+}
+
+// stageAt returns the index of the stage that is current elapsed into the
+// run. The last stage carries on once they are all over.
+func stageAt(stages []stage, elapsed time.Duration) int {
+	for i, s := range stages {
+		if elapsed < s.duration {
+			return i
+		}
+		elapsed -= s.duration
+	}
+	return len(stages) - 1
+}
+
+// stagesRate returns a rate that steps through stages
+func stagesRate(stages []stage) func(elapsed time.Duration) float64 {
+	return func(elapsed time.Duration) float64 {
+		return stages[stageAt(stages, elapsed)].rate
+	}
+}
+
+func newStageResults(stages []stage) []*breakdownResult {
+	results := make([]*breakdownResult, len(stages))
+	for i := range results {
+		results[i] = &breakdownResult{latencies: newLatencyHistogram(3)}
+	}
+	return results
+}
+
+// recordStage adds a response to the statistics of the stage it was sent in
+func recordStage(stages []stage, results []*breakdownResult, start time.Time, res *resp) {
+	result := results[stageAt(stages, res.sent.Sub(start))]
+	result.requests++
+	if res.status >= 200 && res.status < 300 && !res.tooSlow {
+		result.success++
+		recordLatency(result.latencies, res.latency)
+	} else {
+		result.failed++
+	}
+}
+
+func printStages(stages []stage, results []*breakdownResult, elapsed time.Duration) {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetRowSeparator("-")
+	table.SetHeader([]string{
+		"Stage",
+		"Duration",
+		"Target",
+		"Requests",
+		"Success",
+		"Failed",
+		"Rate",
+		"50%",
+		"99%",
+		"Max",
+	})
+	for i, s := range stages {
+		// The run may have ended part way through a stage, or the last
+		// stage may have carried on after its time
+		ran := s.duration
+		if i == len(stages)-1 || elapsed < ran {
+			ran = elapsed
+		}
+		elapsed -= ran
+		if ran <= 0 {
+			break
+		}
+		result := results[i]
+		table.Append([]string{
+			bold(fmt.Sprintf("%d", i+1)),
+			ran.Round(time.Second).String(),
+			fmt.Sprintf("%.0f hits/sec", s.rate),
+			fmt.Sprintf("%d", result.requests),
+			fmt.Sprintf("%d", result.success),
+			fmt.Sprintf("%d", result.failed),
+			fmt.Sprintf("%.0f hits/sec", float64(result.success)/ran.Seconds()),
+			formatLatency(result.latencies.ValueAtPercentile(50)),
+			formatLatency(result.latencies.ValueAtPercentile(99)),
+			formatLatency(result.latencies.Max()),
+		})
+	}
+	table.Render()
+	fmt.Println("")
+}
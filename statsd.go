@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// statsdPacketSize keeps packets within the MTU of most networks so that
+// they aren't fragmented, and lost, on the way to the agent
+const statsdPacketSize = 1432
+
+// statsdClient sends the timing and outcome of every request to a StatsD or
+// DogStatsD agent as the run goes. Metrics are packed several to a UDP
+// packet and each batch of responses is sent as soon as it's recorded.
+type statsdClient struct {
+	conn   net.Conn
+	prefix string
+	tags   string
+	packet bytes.Buffer
+}
+
+// newStatsdClient sends metrics named prefix.* to address. tags, as
+// key:value,key:value, are added to every metric in the DogStatsD format.
+func newStatsdClient(address string, prefix string, tags string) (*statsdClient, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	s := &statsdClient{conn: conn, prefix: strings.TrimSuffix(prefix, ".")}
+	if s.prefix != "" {
+		s.prefix += "."
+	}
+	if tags != "" {
+		s.tags = "|#" + tags
+	}
+	return s, nil
+}
+
+// record adds the metrics of a response to the packet being built
+func (s *statsdClient) record(res *resp) {
+	s.metric("requests", "1", "c")
+	if res.status == 0 {
+		s.metric("network_failed", "1", "c")
+		s.metric("errors", "1", "c")
+		return
+	}
+	s.metric("status."+strconv.Itoa(res.status), "1", "c")
+	if res.status < 200 || res.status >= 300 {
+		s.metric("errors", "1", "c")
+	}
+	s.metric("latency", strconv.FormatFloat(latencyMilliseconds(res.latency), 'f', 3, 64), "ms")
+	s.metric("bytes", strconv.Itoa(res.size), "h")
+}
+
+func (s *statsdClient) metric(name string, value string, kind string) {
+	line := s.prefix + name + ":" + value + "|" + kind + s.tags
+	if s.packet.Len() > 0 && s.packet.Len()+1+len(line) > statsdPacketSize {
+		s.flush()
+	}
+	if s.packet.Len() > 0 {
+		s.packet.WriteByte('\n')
+	}
+	s.packet.WriteString(line)
+}
+
+// flush sends the metrics recorded so far. Nothing is done about a packet
+// that can't be sent: like the agent, gobench treats metrics as best effort.
+func (s *statsdClient) flush() {
+	if s.packet.Len() > 0 {
+		s.conn.Write(s.packet.Bytes())
+		s.packet.Reset()
+	}
+}
+
+func (s *statsdClient) close() error {
+	s.flush()
+	return s.conn.Close()
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// streamedBody is a request body that is read from disk as each request is
+// sent, so that large uploads don't have to fit in memory. It is either a -d
+// file or a multipart/form-data body built from the -form fields, whose
+// encoded headers and boundaries are kept in memory.
+type streamedBody struct {
+	contentType string
+	parts       []bodyPart
+	length      int64
+}
+
+// bodyPart is either a run of encoded bytes or a whole file
+type bodyPart struct {
+	data []byte
+	file *os.File
+	size int64
+}
+
+// newMultipartBody builds the body from fields given as name=value, or
+// name=@path to upload the file at path
+func newMultipartBody(fields []string) (*streamedBody, error) {
+	body := &streamedBody{}
+	var buffer bytes.Buffer
+	writer := multipart.NewWriter(&buffer)
+	flush := func() {
+		if buffer.Len() > 0 {
+			data := append([]byte(nil), buffer.Bytes()...)
+			body.parts = append(body.parts, bodyPart{data: data, size: int64(len(data))})
+			body.length += int64(len(data))
+			buffer.Reset()
+		}
+	}
+
+	for _, field := range fields {
+		name, value, ok := strings.Cut(field, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("form fields must be given as name=value or name=@file: %s", field)
+		}
+		if !strings.HasPrefix(value, "@") {
+			if err := writer.WriteField(name, value); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		file, err := os.Open(value[1:])
+		if err != nil {
+			return nil, err
+		}
+		info, err := file.Stat()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := writer.CreateFormFile(name, filepath.Base(value[1:])); err != nil {
+			return nil, err
+		}
+		flush()
+		body.parts = append(body.parts, bodyPart{file: file, size: info.Size()})
+		body.length += info.Size()
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	flush()
+	body.contentType = writer.FormDataContentType()
+	return body, nil
+}
+
+// newFileBody returns the body that streams the file at path
+func newFileBody(path string) (*streamedBody, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return &streamedBody{
+		parts:  []bodyPart{{file: file, size: info.Size()}},
+		length: info.Size(),
+	}, nil
+}
+
+// reader returns a reader of the whole body. Any number of readers can be in
+// use at once.
+func (b *streamedBody) reader() io.Reader {
+	readers := make([]io.Reader, len(b.parts))
+	for i, part := range b.parts {
+		if part.file != nil {
+			readers[i] = io.NewSectionReader(part.file, 0, part.size)
+		} else {
+			readers[i] = bytes.NewReader(part.data)
+		}
+	}
+	return io.MultiReader(readers...)
+}
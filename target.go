@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/glentiki/hdrhistogram"
+	"github.com/olekukonko/tablewriter"
+)
+
+// targetInterval is how often -target-p99 looks at the latency and adjusts
+// the rate
+const targetInterval = 2 * time.Second
+
+// targetStep is one adjustment of the rate by -target-p99
+type targetStep struct {
+	elapsed  time.Duration
+	rate     float64
+	achieved float64
+	p99      int64
+	requests int64
+}
+
+// latencyTarget adjusts the rate of a pacer up while the 99th percentile
+// latency is within target and down when it isn't, to find the most load
+// that keeps to it
+type latencyTarget struct {
+	sync.Mutex
+	target time.Duration
+	rate   float64
+
+	// window is the latency of the responses since the last adjustment
+	window *hdrhistogram.Histogram
+	steps  []targetStep
+}
+
+func newLatencyTarget(target time.Duration, rate float64) *latencyTarget {
+	return &latencyTarget{target: target, rate: rate, window: newLatencyHistogram(3)}
+}
+
+// currentRate is the rate function of the pacer
+func (t *latencyTarget) currentRate(time.Duration) float64 {
+	t.Lock()
+	defer t.Unlock()
+	return t.rate
+}
+
+// record adds the latency of a successful response. It is called from the
+// goroutine that collects the results, as is adjust.
+func (t *latencyTarget) record(latency int64) {
+	recordLatency(t.window, latency)
+}
+
+// adjust sets the rate for the next interval from the latency of the last
+func (t *latencyTarget) adjust(elapsed time.Duration) {
+	requests := t.window.TotalCount()
+	p99 := t.window.ValueAtPercentile(99)
+	t.window.Reset()
+
+	t.Lock()
+	defer t.Unlock()
+	step := targetStep{
+		elapsed:  elapsed,
+		rate:     t.rate,
+		achieved: float64(requests) / targetInterval.Seconds(),
+		p99:      p99,
+		requests: requests,
+	}
+	t.steps = append(t.steps, step)
+	switch {
+	case requests == 0:
+		// Nothing to go on
+	case time.Duration(p99)*time.Microsecond > t.target:
+		t.rate *= 0.8
+	case step.achieved >= 0.9*t.rate:
+		// Only go faster while the clients keep up with the rate, as
+		// otherwise -c rather than the latency is what limits it
+		t.rate *= 1.25
+	}
+}
+
+// equilibrium returns the highest throughput of an interval that kept to the
+// target, and its 99th percentile latency
+func (t *latencyTarget) equilibrium() (float64, int64) {
+	var best float64
+	var p99 int64
+	for _, step := range t.steps {
+		if step.requests > 0 && time.Duration(step.p99)*time.Microsecond <= t.target && step.achieved > best {
+			best, p99 = step.achieved, step.p99
+		}
+	}
+	return best, p99
+}
+
+func (t *latencyTarget) print() {
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetRowSeparator("-")
+	table.SetHeader([]string{
+		"Time",
+		"Target rate",
+		"Rate",
+		"99%",
+	})
+	for _, step := range t.steps {
+		p99 := formatLatency(step.p99)
+		if time.Duration(step.p99)*time.Microsecond > t.target {
+			p99 = red(p99)
+		}
+		table.Append([]string{
+			bold(step.elapsed.Round(time.Second).String()),
+			fmt.Sprintf("%.0f hits/sec", step.rate),
+			fmt.Sprintf("%.0f hits/sec", step.achieved),
+			p99,
+		})
+	}
+	table.Render()
+	if rate, p99 := t.equilibrium(); rate > 0 {
+		fmt.Printf("Highest rate with p99 within %v: %.0f hits/sec (p99 %s)\n", t.target, rate, formatLatency(p99))
+	} else {
+		fmt.Printf("No interval kept p99 within %v\n", t.target)
+	}
+	fmt.Println("")
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// tcpInfo is the part of a socket's TCP_INFO that -tcp-info reports
+type tcpInfo struct {
+	rtt          time.Duration
+	rttVar       time.Duration
+	cwnd         uint32
+	totalRetrans uint32
+}
+
+// tcpStat tracks the range and mean of a sampled value
+type tcpStat struct {
+	min, max, sum float64
+}
+
+func (s *tcpStat) add(value float64, samples int64) {
+	if samples == 0 || value < s.min {
+		s.min = value
+	}
+	if value > s.max {
+		s.max = value
+	}
+	s.sum += value
+}
+
+// tcpInfoSampler keeps track of open connections and summarizes their
+// TCP_INFO, sampled every interval and once more as each connection closes.
+type tcpInfoSampler struct {
+	sync.Mutex
+	conns       map[*MyConn]uint32 // connection -> retransmits seen so far
+	samples     int64
+	rtt         tcpStat
+	rttVar      tcpStat
+	cwnd        tcpStat
+	retransmits int64
+}
+
+// tcpInfoSamples is nil unless -tcp-info is set
+var tcpInfoSamples *tcpInfoSampler
+
+func newTCPInfoSampler(interval time.Duration) *tcpInfoSampler {
+	sampler := &tcpInfoSampler{conns: make(map[*MyConn]uint32)}
+	go func() {
+		for range time.Tick(interval) {
+			sampler.sampleAll()
+		}
+	}()
+	return sampler
+}
+
+func (s *tcpInfoSampler) register(conn *MyConn) {
+	s.Lock()
+	s.conns[conn] = 0
+	s.Unlock()
+}
+
+// unregister takes a last sample of a connection that is about to close
+func (s *tcpInfoSampler) unregister(conn *MyConn) {
+	s.sample(conn)
+	s.Lock()
+	delete(s.conns, conn)
+	s.Unlock()
+}
+
+func (s *tcpInfoSampler) sampleAll() {
+	s.Lock()
+	conns := make([]*MyConn, 0, len(s.conns))
+	for conn := range s.conns {
+		conns = append(conns, conn)
+	}
+	s.Unlock()
+	for _, conn := range conns {
+		s.sample(conn)
+	}
+}
+
+func (s *tcpInfoSampler) sample(conn *MyConn) {
+	info, err := readTCPInfo(conn.Conn)
+	if err != nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	seen, ok := s.conns[conn]
+	if !ok {
+		return
+	}
+	if info.totalRetrans > seen {
+		s.retransmits += int64(info.totalRetrans - seen)
+		s.conns[conn] = info.totalRetrans
+	}
+	s.rtt.add(float64(info.rtt)/float64(time.Millisecond), s.samples)
+	s.rttVar.add(float64(info.rttVar)/float64(time.Millisecond), s.samples)
+	s.cwnd.add(float64(info.cwnd), s.samples)
+	s.samples++
+}
+
+func (s *tcpInfoSampler) print() {
+	s.Lock()
+	defer s.Unlock()
+	fmt.Printf("TCP_INFO samples:               %10d\n", s.samples)
+	fmt.Printf("Retransmitted segments:         %10d\n", s.retransmits)
+	if s.samples == 0 {
+		fmt.Println("")
+		return
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetRowSeparator("-")
+	table.SetHeader([]string{"TCP_INFO", "Min", "Avg", "Max"})
+	n := float64(s.samples)
+	table.Append([]string{"RTT", fmt.Sprintf("%.2f ms", s.rtt.min), fmt.Sprintf("%.2f ms", s.rtt.sum/n), fmt.Sprintf("%.2f ms", s.rtt.max)})
+	table.Append([]string{"RTT variance", fmt.Sprintf("%.2f ms", s.rttVar.min), fmt.Sprintf("%.2f ms", s.rttVar.sum/n), fmt.Sprintf("%.2f ms", s.rttVar.max)})
+	table.Append([]string{"Congestion window", fmt.Sprintf("%.0f", s.cwnd.min), fmt.Sprintf("%.1f", s.cwnd.sum/n), fmt.Sprintf("%.0f", s.cwnd.max)})
+	table.Render()
+	fmt.Println("")
+}
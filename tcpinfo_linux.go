@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const tcpInfoSupported = true
+
+// readTCPInfo reads TCP_INFO from the socket underlying conn
+func readTCPInfo(conn net.Conn) (tcpInfo, error) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return tcpInfo{}, errors.New("not a socket")
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return tcpInfo{}, err
+	}
+	var info syscall.TCPInfo
+	var errno syscall.Errno
+	err = raw.Control(func(fd uintptr) {
+		size := uint32(syscall.SizeofTCPInfo)
+		_, _, errno = syscall.Syscall6(syscall.SYS_GETSOCKOPT, fd, syscall.IPPROTO_TCP, syscall.TCP_INFO,
+			uintptr(unsafe.Pointer(&info)), uintptr(unsafe.Pointer(&size)), 0)
+	})
+	if err != nil {
+		return tcpInfo{}, err
+	}
+	if errno != 0 {
+		return tcpInfo{}, errno
+	}
+	return tcpInfo{
+		rtt:          time.Duration(info.Rtt) * time.Microsecond,
+		rttVar:       time.Duration(info.Rttvar) * time.Microsecond,
+		cwnd:         info.Snd_cwnd,
+		totalRetrans: info.Total_retrans,
+	}, nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+const tcpInfoSupported = false
+
+func readTCPInfo(conn net.Conn) (tcpInfo, error) {
+	return tcpInfo{}, errors.New("TCP_INFO is only available on Linux")
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math/rand"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// placeholder matches {{name args...}} in a template
+var placeholder = regexp.MustCompile(`{{\s*([A-Za-z_][A-Za-z0-9_]*)((?:\s+[^\s}]+)*)\s*}}`)
+
+// templateSequence numbers the requests for {{seq}}
+var templateSequence int64
+
+// dataColumns maps the names of the columns of the -data-csv file to their
+// index in each row
+var dataColumns map[string]int
+
+// template is a request body or URL with placeholders that are filled in
+// for each request:
+//
+//	{{uuid}}        a random UUID
+//	{{seq}}         a number that goes up by one for each request
+//	{{timestamp}}   the Unix time in seconds
+//	{{rand}}        a random number
+//	{{rand A B}}    a random integer from A to B inclusive
+//	{{column}}      the value of the column of that name in the current row
+//	                of the -data-csv file
+//
+// Any other {{name}} is left as it is, so that bodies that are templates of
+// their own, such as Mustache or Handlebars, are sent unchanged.
+type template struct {
+	literals []string
+	fields   []func(row []string) string
+}
+
+// parseTemplate returns the template for text, or nil if text has no
+// placeholders that gobench fills in
+func parseTemplate(text string) (*template, error) {
+	matches := placeholder.FindAllStringSubmatchIndex(text, -1)
+	if matches == nil {
+		return nil, nil
+	}
+	t := &template{}
+	last := 0
+	for _, match := range matches {
+		name := text[match[2]:match[3]]
+		args := strings.Fields(text[match[4]:match[5]])
+		field, err := templateField(name, args)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", text[match[0]:match[1]], err)
+		}
+		if field == nil {
+			continue
+		}
+		t.literals = append(t.literals, text[last:match[0]])
+		t.fields = append(t.fields, field)
+		last = match[1]
+	}
+	if len(t.fields) == 0 {
+		return nil, nil
+	}
+	t.literals = append(t.literals, text[last:])
+	return t, nil
+}
+
+// templateField returns the function that fills in the placeholder name, or
+// nil if it isn't one of gobench's
+func templateField(name string, args []string) (func(row []string) string, error) {
+	if column, ok := dataColumns[name]; ok && len(args) == 0 {
+		return func(row []string) string {
+			return row[column]
+		}, nil
+	}
+	switch name {
+	case "uuid", "seq", "timestamp", "rand":
+	default:
+		return nil, nil
+	}
+	if name != "rand" && len(args) > 0 {
+		return nil, fmt.Errorf("%s takes no arguments", name)
+	}
+	switch name {
+	case "uuid":
+		return func([]string) string {
+			return newUUID()
+		}, nil
+	case "seq":
+		return func([]string) string {
+			return strconv.FormatInt(atomic.AddInt64(&templateSequence, 1), 10)
+		}, nil
+	case "timestamp":
+		return func([]string) string {
+			return strconv.FormatInt(time.Now().Unix(), 10)
+		}, nil
+	case "rand":
+		if len(args) == 0 {
+			return func([]string) string {
+				return strconv.FormatUint(uint64(rand.Int63()), 36)
+			}, nil
+		}
+		if len(args) != 2 {
+			return nil, fmt.Errorf("rand takes a lowest and a highest value")
+		}
+		low, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		high, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		if high < low {
+			return nil, fmt.Errorf("rand lowest value %d is more than highest %d", low, high)
+		}
+		return func([]string) string {
+			return strconv.FormatInt(low+rand.Int63n(high-low+1), 10)
+		}, nil
+	}
+	return nil, nil
+}
+
+// expand returns the text with its placeholders filled in, taking column
+// values from row
+func (t *template) expand(row []string) string {
+	var text strings.Builder
+	for i, field := range t.fields {
+		text.WriteString(t.literals[i])
+		text.WriteString(field(row))
+	}
+	text.WriteString(t.literals[len(t.literals)-1])
+	return text.String()
+}
+
+// loadDataCSV reads a CSV file whose first row names its columns
+func loadDataCSV(fileName string) (map[string]int, [][]string, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(records) < 2 {
+		return nil, nil, fmt.Errorf("a header row and at least one row of data are needed")
+	}
+	columns := make(map[string]int)
+	for i, name := range records[0] {
+		columns[strings.TrimSpace(name)] = i
+	}
+	return columns, records[1:], nil
+}
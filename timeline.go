@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timelineRow is the throughput and latency of one -timeline-interval
+type timelineRow struct {
+	Offset   float64 `json:"offset_seconds"`
+	Duration float64 `json:"duration_seconds"`
+	Requests int64   `json:"requests"`
+	Success  int64   `json:"success"`
+	Failed   int64   `json:"failed"`
+	Rate     float64 `json:"success_per_second"`
+	P50      float64 `json:"p50_ms"`
+	P90      float64 `json:"p90_ms"`
+	P99      float64 `json:"p99_ms"`
+	P999     float64 `json:"p99.9_ms"`
+	Max      float64 `json:"max_ms"`
+	Mean     float64 `json:"mean_ms"`
+}
+
+var timelineColumns = []string{
+	"offset_seconds",
+	"duration_seconds",
+	"requests",
+	"success",
+	"failed",
+	"success_per_second",
+	"p50_ms",
+	"p90_ms",
+	"p99_ms",
+	"p99.9_ms",
+	"max_ms",
+	"mean_ms",
+}
+
+// latencyTimeline records the latency percentiles and throughput of every
+// -timeline-interval of a run, so that warm-up, pauses and degradation show
+// up rather than being averaged away by the run-wide histogram
+type latencyTimeline struct {
+	start    time.Time
+	interval *intervalSummary
+	rows     []timelineRow
+}
+
+func newLatencyTimeline(start time.Time) *latencyTimeline {
+	return &latencyTimeline{start: start, interval: newIntervalSummary(start)}
+}
+
+func (t *latencyTimeline) record(res *resp) {
+	t.interval.record(res)
+}
+
+// tick ends the interval at now
+func (t *latencyTimeline) tick(now time.Time) {
+	s := t.interval
+	duration := now.Sub(s.start).Seconds()
+	if duration <= 0 {
+		return
+	}
+	t.rows = append(t.rows, timelineRow{
+		Offset:   s.start.Sub(t.start).Seconds(),
+		Duration: duration,
+		Requests: s.requests,
+		Success:  s.success,
+		Failed:   s.failed,
+		Rate:     float64(s.success) / duration,
+		P50:      latencyMilliseconds(s.latencies.ValueAtPercentile(50)),
+		P90:      latencyMilliseconds(s.latencies.ValueAtPercentile(90)),
+		P99:      latencyMilliseconds(s.latencies.ValueAtPercentile(99)),
+		P999:     latencyMilliseconds(s.latencies.ValueAtPercentile(99.9)),
+		Max:      latencyMilliseconds(s.latencies.Max()),
+		Mean:     s.latencies.Mean() / 1000,
+	})
+	s.reset(now)
+}
+
+// close ends the last, partial, interval if anything happened in it
+func (t *latencyTimeline) close(now time.Time) {
+	if t.interval.requests > 0 {
+		t.tick(now)
+	}
+}
+
+// save writes the timeline to fileName, as JSON if it ends in .json and CSV
+// otherwise
+func (t *latencyTimeline) save(fileName string) error {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(filepath.Ext(fileName), ".json") {
+		encoder := json.NewEncoder(file)
+		encoder.SetIndent("", "  ")
+		rows := t.rows
+		if rows == nil {
+			rows = []timelineRow{}
+		}
+		err = encoder.Encode(rows)
+	} else {
+		err = t.writeCSV(file)
+	}
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func (t *latencyTimeline) writeCSV(file *os.File) error {
+	float := func(f float64) string {
+		return strconv.FormatFloat(f, 'f', 3, 64)
+	}
+	integer := func(i int64) string {
+		return strconv.FormatInt(i, 10)
+	}
+	writer := csv.NewWriter(file)
+	writer.Write(timelineColumns)
+	for _, row := range t.rows {
+		writer.Write([]string{
+			float(row.Offset),
+			float(row.Duration),
+			integer(row.Requests),
+			integer(row.Success),
+			integer(row.Failed),
+			float(row.Rate),
+			float(row.P50),
+			float(row.P90),
+			float(row.P99),
+			float(row.P999),
+			float(row.Max),
+			float(row.Mean),
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
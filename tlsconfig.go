@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+// curves maps the names accepted by -curves, as used in the IANA TLS
+// Supported Groups registry, to their IDs
+var curves = []struct {
+	name string
+	id   tls.CurveID
+}{
+	{"X25519", tls.X25519},
+	{"P-256", tls.CurveP256},
+	{"P-384", tls.CurveP384},
+	{"P-521", tls.CurveP521},
+	{"X25519MLKEM768", tls.X25519MLKEM768},
+	{"SecP256r1MLKEM768", tls.SecP256r1MLKEM768},
+	{"SecP384r1MLKEM1024", tls.SecP384r1MLKEM1024},
+	{"MLKEM1024", tls.MLKEM1024},
+}
+
+// parseCurves turns a comma separated list of curve names into the
+// preference order for tls.Config.CurvePreferences
+func parseCurves(list string) ([]tls.CurveID, error) {
+	var preferences []tls.CurveID
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		found := false
+		for _, c := range curves {
+			if strings.EqualFold(name, c.name) {
+				preferences = append(preferences, c.id)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown curve: %s", name)
+		}
+	}
+	return preferences, nil
+}
+
+func printCurveNames() {
+	for _, c := range curves {
+		fmt.Println(c.name)
+	}
+}
+
+// curveName returns the name -curves uses for id
+func curveName(id tls.CurveID) string {
+	for _, c := range curves {
+		if c.id == id {
+			return c.name
+		}
+	}
+	return id.String()
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/glentiki/hdrhistogram"
+)
+
+// Counts of completed TLS handshakes, split by whether they resumed an
+// earlier session
+var fullHandshakes int64
+var resumedHandshakes int64
+
+// tlsHandshakeTimes records how long the TLS handshakes over TCP took
+var tlsHandshakeTimes = struct {
+	sync.Mutex
+	latencies *hdrhistogram.Histogram
+}{}
+
+// negotiatedCurves counts handshakes by the key exchange group negotiated
+var negotiatedCurves = struct {
+	sync.Mutex
+	counts map[tls.CurveID]int64
+}{counts: make(map[tls.CurveID]int64)}
+
+// negotiatedProtocols counts handshakes by the ALPN protocol negotiated, when
+// one was offered with -alpn
+var negotiatedProtocols = struct {
+	sync.Mutex
+	counts map[string]int64
+}{counts: make(map[string]int64)}
+
+// Counts of handshakes where the server accepted or rejected the Encrypted
+// Client Hello offered with -ech
+var echAccepted int64
+var echRejected int64
+
+// verifyConnection is called by crypto/tls at the end of every client
+// handshake, including resumed ones, and records what was negotiated.
+func verifyConnection(state tls.ConnectionState) error {
+	if showCert {
+		showCertificates(state)
+	}
+	if state.DidResume {
+		atomic.AddInt64(&resumedHandshakes, 1)
+	} else {
+		atomic.AddInt64(&fullHandshakes, 1)
+	}
+	if state.CurveID != 0 {
+		negotiatedCurves.Lock()
+		negotiatedCurves.counts[state.CurveID]++
+		negotiatedCurves.Unlock()
+	}
+	if alpnList != "" {
+		protocol := state.NegotiatedProtocol
+		if protocol == "" {
+			protocol = "none"
+		}
+		negotiatedProtocols.Lock()
+		negotiatedProtocols.counts[protocol]++
+		negotiatedProtocols.Unlock()
+	}
+	if state.ECHAccepted {
+		atomic.AddInt64(&echAccepted, 1)
+	}
+	return nil
+}
+
+func recordTLSHandshake(d time.Duration) {
+	tlsHandshakeTimes.Lock()
+	defer tlsHandshakeTimes.Unlock()
+	if tlsHandshakeTimes.latencies == nil {
+		tlsHandshakeTimes.latencies = newLatencyHistogram(3)
+	}
+	recordLatency(tlsHandshakeTimes.latencies, latencyMicroseconds(d))
+}
+
+func resetTLSHandshakes() {
+	tlsHandshakeTimes.Lock()
+	tlsHandshakeTimes.latencies = nil
+	tlsHandshakeTimes.Unlock()
+}
+
+// tlsHandshakeReport is the time the TLS handshakes took for the JSON report,
+// or nil if there were none
+func tlsHandshakeReport() *reportLatency {
+	tlsHandshakeTimes.Lock()
+	defer tlsHandshakeTimes.Unlock()
+	if tlsHandshakeTimes.latencies == nil {
+		return nil
+	}
+	latency := newReportLatency(tlsHandshakeTimes.latencies)
+	return &latency
+}
+
+func printTLSHandshakes() {
+	tlsHandshakeTimes.Lock()
+	defer tlsHandshakeTimes.Unlock()
+	latencies := tlsHandshakeTimes.latencies
+	if latencies == nil {
+		return
+	}
+	fmt.Printf("TLS handshake time (mean):      %10.2f ms\n", latencies.Mean()/1000)
+	fmt.Printf("TLS handshake time (99%%):       %10.2f ms\n", latencyMilliseconds(latencies.ValueAtPercentile(99)))
+	fmt.Printf("TLS handshake time (max):       %10.2f ms\n", latencyMilliseconds(latencies.Max()))
+}
+
+// countHandshakeError records handshake failures that are reported in their
+// own right rather than only as network failures
+func countHandshakeError(err error) {
+	var rejection *tls.ECHRejectionError
+	if errors.As(err, &rejection) {
+		atomic.AddInt64(&echRejected, 1)
+	}
+	var verification *tls.CertificateVerificationError
+	if showCert && errors.As(err, &verification) {
+		showRejectedCertificates(verification)
+	}
+}
+
+// printCurves prints how many handshakes negotiated each key exchange group
+func printCurves() {
+	negotiatedCurves.Lock()
+	defer negotiatedCurves.Unlock()
+	ids := make([]tls.CurveID, 0, len(negotiatedCurves.counts))
+	for id := range negotiatedCurves.counts {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		fmt.Printf("Key exchange %-18s %10d handshakes\n", curveName(id)+":", negotiatedCurves.counts[id])
+	}
+}
+
+// printProtocols prints how many handshakes negotiated each ALPN protocol
+func printProtocols() {
+	negotiatedProtocols.Lock()
+	defer negotiatedProtocols.Unlock()
+	protocols := make([]string, 0, len(negotiatedProtocols.counts))
+	for protocol := range negotiatedProtocols.counts {
+		protocols = append(protocols, protocol)
+	}
+	sort.Strings(protocols)
+	for _, protocol := range protocols {
+		fmt.Printf("ALPN %-26s %10d handshakes\n", protocol+":", negotiatedProtocols.counts[protocol])
+	}
+}
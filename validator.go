@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// validation is a response waiting to be checked by -validator-cmd
+type validation struct {
+	url      string
+	status   int
+	body     []byte
+	trailers []string
+}
+
+// validator pipes a sample of response bodies to an external command and
+// counts its exit codes, 0 meaning the response is valid. The command runs in
+// the background so that it doesn't hold up the client; responses that arrive
+// while every runner is busy are skipped.
+type validator struct {
+	command string
+	queue   chan validation
+	pending sync.WaitGroup
+	skipped int64
+
+	sync.Mutex
+	exitCodes map[int]int64
+	errors    int64
+	firstFail string
+}
+
+func newValidator(command string, runners int) *validator {
+	v := &validator{
+		command:   command,
+		queue:     make(chan validation, runners),
+		exitCodes: make(map[int]int64),
+	}
+	for i := 0; i < runners; i++ {
+		go v.runner()
+	}
+	return v
+}
+
+// submit queues a response for validation unless the validator is busy
+func (v *validator) submit(url string, status int, body []byte, trailers []string) {
+	v.pending.Add(1)
+	select {
+	case v.queue <- validation{url: url, status: status, body: body, trailers: trailers}:
+	default:
+		v.pending.Done()
+		atomic.AddInt64(&v.skipped, 1)
+	}
+}
+
+func (v *validator) runner() {
+	for job := range v.queue {
+		v.validate(job)
+		v.pending.Done()
+	}
+}
+
+func (v *validator) validate(job validation) {
+	cmd := exec.Command("/bin/sh", "-c", v.command)
+	cmd.Stdin = bytes.NewReader(job.body)
+	cmd.Env = append(os.Environ(),
+		"GOBENCH_URL="+job.url,
+		"GOBENCH_STATUS="+strconv.Itoa(job.status),
+		"GOBENCH_TRAILERS="+strings.Join(job.trailers, "\n"))
+	output, err := cmd.CombinedOutput()
+
+	v.Lock()
+	defer v.Unlock()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		v.exitCodes[0]++
+	case errors.As(err, &exitErr):
+		v.exitCodes[exitErr.ExitCode()]++
+		if v.firstFail == "" {
+			v.firstFail = fmt.Sprintf("%s (exit %d): %s", job.url, exitErr.ExitCode(), strings.TrimSpace(string(output)))
+		}
+	default:
+		v.errors++
+		if v.firstFail == "" {
+			v.firstFail = fmt.Sprintf("%s: %s", job.url, err)
+		}
+	}
+}
+
+// wait blocks until every queued response has been validated
+func (v *validator) wait() {
+	v.pending.Wait()
+}
+
+func (v *validator) print() {
+	v.Lock()
+	defer v.Unlock()
+	codes := make([]int, 0, len(v.exitCodes))
+	for code := range v.exitCodes {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetRowSeparator("-")
+	table.SetHeader([]string{"Validator exit code", "Responses"})
+	for _, code := range codes {
+		name := strconv.Itoa(code)
+		if code == 0 {
+			name += " (valid)"
+		}
+		table.Append([]string{name, fmt.Sprintf("%d", v.exitCodes[code])})
+	}
+	table.Render()
+	if v.errors > 0 {
+		fmt.Println("Validator failed to run:", v.errors)
+	}
+	if skipped := atomic.LoadInt64(&v.skipped); skipped > 0 {
+		fmt.Println("Skipped while the validator was busy:", skipped)
+	}
+	if v.firstFail != "" {
+		fmt.Println("First failure:", v.firstFail)
+	}
+	fmt.Println("")
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// delayedArrivals counts the virtual users that arrived while -c of them were
+// already running and had to wait for one to leave
+var delayedArrivals int64
+
+// virtualUsers starts a virtual user at each arrival, at -vu-rate a second,
+// until ctx is done. Each user has its own connections, sends -vu-session
+// requests and leaves. At most len(slots) users run at once, and each adds its
+// results to the slot it ran in when it leaves.
+func virtualUsers(ctx context.Context, configuration *Configuration, slots []*Result, errChan chan error, batchChan chan []resp, dumpChan chan string, exitChan chan bool) {
+	free := make(chan int, len(slots))
+	for i := range slots {
+		free <- i
+	}
+	var wg sync.WaitGroup
+	defer func() {
+		wg.Wait()
+		exitChan <- true
+	}()
+
+	arrivals := newPacer(func(time.Duration) float64 { return vuRate })
+	for {
+		arrivals.wait(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		var slot int
+		select {
+		case slot = <-free:
+		default:
+			atomic.AddInt64(&delayedArrivals, 1)
+			select {
+			case slot = <-free:
+			case <-ctx.Done():
+				return
+			}
+		}
+		wg.Add(1)
+		go func(slot int) {
+			defer wg.Done()
+			user := configuration.withKeepAlive(configuration.keepAlive)
+			// A queue of its own stops the user at exactly -vu-session
+			// requests, where -r would finish a round of the URLs
+			user.queue = &workQueue{total: int64(vuSession)}
+			result := &Result{}
+			client(ctx, user, result, errChan, batchChan, dumpChan, make(chan bool, 1))
+			user.myClient.CloseIdleConnections()
+			result.sessions++
+			slots[slot].add(result)
+			free <- slot
+		}(slot)
+	}
+}
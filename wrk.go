@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// wrkDuration formats a latency in milliseconds the way wrk does
+func wrkDuration(ms float64) string {
+	switch {
+	case ms < 1:
+		return fmt.Sprintf("%.2fus", ms*1000)
+	case ms < 1000:
+		return fmt.Sprintf("%.2fms", ms)
+	}
+	return fmt.Sprintf("%.2fs", ms/1000)
+}
+
+// wrkBytes formats a number of bytes the way wrk does
+func wrkBytes(bytes float64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	unit := 0
+	for bytes >= 1024 && unit < len(units)-1 {
+		bytes /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.2f%s", bytes, units[unit])
+}
+
+// writeWrk writes the summary of a run in the layout wrk prints, for the
+// scripts and parsers that read wrk's output
+func writeWrk(out io.Writer, configuration *Configuration, stats *runStats) {
+	c := stats.collector
+	latencies := c.latencies
+	sum := total(stats.results)
+	seconds := stats.elapsed.Seconds()
+	if seconds <= 0 {
+		seconds = 1
+	}
+
+	// The share of latencies within a standard deviation of the mean
+	mean, stdDev := latencies.Mean()/1000, latencies.StdDev()/1000
+	var within int64
+	for _, bar := range latencies.Distribution() {
+		if latencyMilliseconds(bar.From) >= mean-stdDev && latencyMilliseconds(bar.To) <= mean+stdDev {
+			within += bar.Count
+		}
+	}
+	withinShare := 100.0
+	if latencies.TotalCount() > 0 {
+		withinShare = float64(within) / float64(latencies.TotalCount()) * 100
+	}
+
+	fmt.Fprintf(out, "Running %s test @ %s\n", stats.elapsed.Round(time.Second), configuration.urls[0])
+	fmt.Fprintf(out, "  %d threads and %d connections\n", clients, clients)
+	fmt.Fprintf(out, "  Thread Stats   Avg      Stdev     Max   +/- Stdev\n")
+	fmt.Fprintf(out, "    Latency   %8s  %8s  %8s  %7.2f%%\n",
+		wrkDuration(mean), wrkDuration(stdDev), wrkDuration(latencyMilliseconds(latencies.Max())), withinShare)
+	fmt.Fprintf(out, "  Latency Distribution\n")
+	for _, percentile := range []float64{50, 75, 90, 99} {
+		fmt.Fprintf(out, "  %3.0f%%  %8s\n", percentile, wrkDuration(latencyMilliseconds(latencies.ValueAtPercentile(percentile))))
+	}
+	read := float64(atomic.LoadInt64(&readThroughput))
+	fmt.Fprintf(out, "  %d requests in %.2fs, %s read\n", sum.requests, stats.elapsed.Seconds(), wrkBytes(read))
+
+	errors := c.networkErrors
+	if len(errors) > 0 {
+		connect := errors[errorRefused] + errors[errorDNS] + errors[errorTLS]
+		reading := errors[errorReset] + errors[errorClosed] + errors[errorOther]
+		fmt.Fprintf(out, "  Socket errors: connect %d, read %d, write %d, timeout %d\n", connect, reading, 0, errors[errorTimeout])
+	}
+	var non2xx3xx int64
+	for status, count := range c.statuses {
+		if status < 200 || status >= 400 {
+			non2xx3xx += count
+		}
+	}
+	if non2xx3xx > 0 {
+		fmt.Fprintf(out, "  Non-2xx or 3xx responses: %d\n", non2xx3xx)
+	}
+	fmt.Fprintf(out, "Requests/sec: %10.2f\n", float64(sum.requests)/seconds)
+	fmt.Fprintf(out, "Transfer/sec: %10s\n", wrkBytes(read/seconds))
+}